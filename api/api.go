@@ -3,32 +3,71 @@ package api
 import (
 	"NestedSetsStorage/configs"
 	"NestedSetsStorage/treestorage"
+	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
+	"io/ioutil"
+	"log"
 	"net/http"
+	"strconv"
+	"time"
 )
 
+const _MAX_BODY_SIZE = 64 << 20 // bytes
+
+const _STREAM_FLUSH_INTERVAL = 100 * time.Millisecond
+
 // Server starts storage
 type Server struct {
-	Config      *configs.Config
-	Storage     *treestorage.NestedSetsStorage
-	apiKeyCache string
+	Config     *configs.Config
+	Storage    treestorage.TreeStore
+	apiKeys    map[string]string // the identities keyed by API key
+	httpServer *http.Server
 }
 
 // Start starts the api server
 func (s *Server) Start() error {
 	http.HandleFunc("/", s.startFace())
-	http.HandleFunc("/all", s.all())
-	http.HandleFunc("/children", s.children())
-	http.HandleFunc("/parents", s.parents())
-	http.HandleFunc("/add", s.add())
-	http.HandleFunc("/move", s.move())
-	http.HandleFunc("/remove", s.remove())
-	http.HandleFunc("/rename", s.rename())
-	http.HandleFunc("/root", s.root())
+	http.HandleFunc("/all", s.tagged(s.all()))
+	http.HandleFunc("/tree", s.tagged(s.nestedTree()))
+	http.HandleFunc("/children", s.tagged(s.children()))
+	http.HandleFunc("/parents", s.tagged(s.parents()))
+	http.HandleFunc("/path", s.tagged(s.path()))
+	http.HandleFunc("/add", s.versioned(s.audited(s.add())))
+	http.HandleFunc("/move", s.versioned(s.audited(s.move())))
+	http.HandleFunc("/remove", s.versioned(s.audited(s.remove())))
+	http.HandleFunc("/rename", s.versioned(s.audited(s.rename())))
+	http.HandleFunc("/reorder", s.versioned(s.audited(s.reorder())))
+	http.HandleFunc("/root", s.versioned(s.audited(s.root())))
+	http.HandleFunc("/trees", s.trees())
+	http.HandleFunc("/trees/add", s.audited(s.addTree()))
+	http.HandleFunc("/trees/remove", s.audited(s.removeTree()))
+	http.HandleFunc("/attributes", s.tagged(s.attributes()))
+	http.HandleFunc("/attributes/set", s.versioned(s.audited(s.updateAttributes())))
+	http.HandleFunc("/attributes/patch", s.versioned(s.audited(s.updateAttributes())))
+	http.HandleFunc("/attributes/delete", s.versioned(s.audited(s.deleteAttribute())))
+	http.HandleFunc("/admin/verify", s.tagged(s.verify()))
+	http.HandleFunc("/admin/rebuild", s.versioned(s.audited(s.rebuild())))
+	http.HandleFunc("/import", s.queryOnly(s.versioned(s.audited(s.importNodes()))))
+	http.HandleFunc("/export", s.tagged(s.export()))
+	http.HandleFunc("/batch", s.queryOnly(s.versioned(s.audited(s.batch()))))
+	http.HandleFunc("/audit", s.audit())
 
-	s.apiKeyCache = s.Config.APIKey
-	return http.ListenAndServe(s.Config.APIPort, nil)
+	s.apiKeys = map[string]string{s.Config.APIKey: "default"}
+	for identity, key := range s.Config.APIKeys {
+		s.apiKeys[key] = identity
+	}
+	s.httpServer = &http.Server{Addr: s.Config.APIPort}
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown gracefully stops the api server
+func (s *Server) Shutdown(ctx context.Context) error {
+	if s.httpServer == nil {
+		return nil
+	}
+	return s.httpServer.Shutdown(ctx)
 }
 
 func (s *Server) startFace() http.HandlerFunc {
@@ -48,13 +87,135 @@ func (s *Server) all() http.HandlerFunc {
 			return
 		}
 
-		data, err := s.Storage.GetWholeTree()
+		opts, err := readOptions(r)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		if r.FormValue("stream") == "true" {
+			s.streamAll(w, r, opts)
+			return
+		}
+		if r.FormValue("limit") != "" || r.FormValue("cursor") != "" {
+			s.pageAll(w, r, opts)
+			return
+		}
+
+		data, err := s.tree(r).GetWholeTreeContext(r.Context(), opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		j, _ := json.Marshal(data)
+		w.Write([]byte(j))
+	}
+}
+
+// pageAll writes a page of the nodes and the cursor of the next page
+func (s *Server) pageAll(w http.ResponseWriter, r *http.Request, opts treestorage.ReadOptions) {
+	limit := 0
+	if l := r.FormValue("limit"); l != "" {
+		var err error
+		limit, err = strconv.Atoi(l)
+		if err != nil || limit < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid limit"))
+			return
+		}
+	}
+
+	data, err := s.tree(r).GetNodesPageContext(r.Context(), r.FormValue("cursor"), limit, opts)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	j, _ := json.Marshal(data)
+	w.Write([]byte(j))
+}
+
+// streamAll writes the nodes as JSON lines while they are read and flushes them at least every
+// _STREAM_FLUSH_INTERVAL, an error after the first node can only break the stream
+func (s *Server) streamAll(w http.ResponseWriter, r *http.Request, opts treestorage.ReadOptions) {
+	flusher, _ := w.(http.Flusher)
+	flush := func() {
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	encoder := json.NewEncoder(w)
+	started := false
+	var flushed time.Time
+	err := s.tree(r).WalkWholeTreeContext(r.Context(), opts, func(node treestorage.NestedSetsNode) error {
+		if !started {
+			w.Header().Set("Content-Type", "application/x-ndjson")
+			w.WriteHeader(http.StatusOK)
+			started = true
+		}
+		err := encoder.Encode(node)
+		if err != nil {
+			return err
+		}
+		if time.Since(flushed) >= _STREAM_FLUSH_INTERVAL {
+			flush()
+			flushed = time.Now()
+		}
+		return nil
+	})
+	if err != nil && started {
+		log.Println(err)
+		return
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if !started {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func (s *Server) nestedTree() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		opts, err := readOptions(r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
+		var data interface{}
+		switch r.FormValue("format") {
+		case "", "nested":
+			data, err = s.tree(r).GetTreeContext(r.Context(), r.FormValue("root"), opts)
+		case "flat":
+			data, err = s.tree(r).GetSubtreeContext(r.Context(), r.FormValue("root"), opts)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid format"))
+			return
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 		j, _ := json.Marshal(data)
 		w.Write([]byte(j))
@@ -72,19 +233,63 @@ func (s *Server) parents() http.HandlerFunc {
 			return
 		}
 
-		data, err := s.Storage.GetParents(r.FormValue("name"))
+		opts, err := readOptions(r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
+		data, err := s.tree(r).GetParentsContext(r.Context(), r.FormValue("name"), opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 		j, _ := json.Marshal(data)
 		w.Write([]byte(j))
 	}
 }
 
+type pathResponse struct {
+	Nodes []treestorage.PathNode
+	Path  string
+}
+
+func (s *Server) path() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		includeSelf := r.FormValue("self") == "true"
+		data, err := s.tree(r).GetPathContext(r.Context(), r.FormValue("name"), includeSelf)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		separator := s.Config.PathSeparator
+		if _, ok := r.Form["separator"]; ok {
+			separator = r.FormValue("separator")
+		} else if separator == "" {
+			separator = treestorage.DefaultPathSeparator
+		}
+
+		w.WriteHeader(http.StatusOK)
+		j, _ := json.Marshal(pathResponse{
+			Nodes: data,
+			Path:  treestorage.RenderPath(data, separator)})
+		w.Write([]byte(j))
+	}
+}
+
 func (s *Server) children() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		r.ParseForm()
@@ -96,13 +301,29 @@ func (s *Server) children() http.HandlerFunc {
 			return
 		}
 
-		data, err := s.Storage.GetChildren(r.FormValue("name"))
+		maxDepth := 0
+		if depth := r.FormValue("depth"); depth != "" {
+			maxDepth, err = strconv.Atoi(depth)
+			if err != nil || maxDepth < 0 {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("invalid depth"))
+				return
+			}
+		}
+
+		opts, err := readOptions(r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
+			w.WriteHeader(http.StatusBadRequest)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
+		data, err := s.tree(r).GetChildrenContext(r.Context(), r.FormValue("name"), maxDepth, opts)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 		j, _ := json.Marshal(data)
 		w.Write([]byte(j))
@@ -120,10 +341,15 @@ func (s *Server) add() http.HandlerFunc {
 			return
 		}
 
-		err = s.Storage.AddNode(r.FormValue("name"), r.FormValue("parent"))
+		pos, err := treestorage.ParsePosition(r.FormValue("position"))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			writeError(w, err)
+			return
+		}
+
+		err = s.tree(r).AddNodeContext(r.Context(), r.FormValue("name"), r.FormValue("parent"), pos)
+		if err != nil {
+			writeError(w, err)
 			return
 		}
 
@@ -143,10 +369,22 @@ func (s *Server) move() http.HandlerFunc {
 			return
 		}
 
-		err = s.Storage.MoveNode(r.FormValue("name"), r.FormValue("parent"))
+		var pos treestorage.Position
+		if position := r.FormValue("position"); position != "" {
+			pos, err = treestorage.ParsePosition(position)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		if r.FormValue("subtree") == "true" {
+			err = s.tree(r).MoveSubtreeContext(r.Context(), r.FormValue("name"), r.FormValue("parent"), pos)
+		} else {
+			err = s.tree(r).MoveNodeContext(r.Context(), r.FormValue("name"), r.FormValue("parent"), pos)
+		}
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			writeError(w, err)
 			return
 		}
 
@@ -166,15 +404,16 @@ func (s *Server) remove() http.HandlerFunc {
 			return
 		}
 
-		err = s.Storage.RemoveNode(r.FormValue("name"))
+		mode := treestorage.RemoveMode(r.FormValue("mode"))
+		data, err := s.tree(r).RemoveNodeContext(r.Context(), r.FormValue("name"), mode, r.FormValue("target"))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
+			writeError(w, err)
 			return
 		}
 
 		w.WriteHeader(http.StatusOK)
-		w.Write([]byte("ok"))
+		j, _ := json.Marshal(data)
+		w.Write([]byte(j))
 	}
 }
 
@@ -189,13 +428,39 @@ func (s *Server) rename() http.HandlerFunc {
 			return
 		}
 
-		err = s.Storage.RenameNode(r.FormValue("name"), r.FormValue("new_name"))
+		err = s.tree(r).RenameNodeContext(r.Context(), r.FormValue("name"), r.FormValue("new_name"))
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
+
+func (s *Server) reorder() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
+		if sortKey := r.FormValue("sort"); sortKey != "" {
+			order := treestorage.SortOrder{Key: sortKey, Descending: r.FormValue("order") == "desc"}
+			err = s.tree(r).SortChildrenContext(r.Context(), r.FormValue("parent"), order)
+		} else {
+			err = s.tree(r).ReorderChildrenContext(r.Context(), r.FormValue("parent"), r.Form["children"])
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	}
@@ -212,20 +477,459 @@ func (s *Server) root() http.HandlerFunc {
 			return
 		}
 
-		err = s.Storage.AddRoot(r.FormValue("name"))
+		err = s.tree(r).AddRootContext(r.Context(), r.FormValue("name"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
+
+func (s *Server) trees() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		data, err := s.Storage.ListTreesContext(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		j, _ := json.Marshal(data)
+		w.Write([]byte(j))
+	}
+}
+
+func (s *Server) addTree() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		err = s.Storage.CreateTreeContext(r.Context(), r.FormValue("name"),
+			treestorage.NameUniqueness(r.FormValue("uniqueness")))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
+
+func (s *Server) removeTree() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		err = s.Storage.DeleteTreeContext(r.Context(), r.FormValue("name"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
+
+func (s *Server) attributes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		data, err := s.tree(r).GetAttributesContext(r.Context(), r.FormValue("name"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		j, _ := json.Marshal(data)
+		w.Write([]byte(j))
+	}
+}
+
+// updateAttributes serves both /attributes/set and /attributes/patch
+func (s *Server) updateAttributes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		var attrs treestorage.Attributes
+		err = json.Unmarshal([]byte(r.FormValue("attributes")), &attrs)
 		if err != nil {
 			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid attributes"))
+			return
+		}
+
+		if r.URL.Path == "/attributes/patch" {
+			err = s.tree(r).PatchAttributesContext(r.Context(), r.FormValue("name"), attrs)
+		} else {
+			err = s.tree(r).SetAttributesContext(r.Context(), r.FormValue("name"), attrs)
+		}
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("ok"))
+	}
+}
+
+func (s *Server) deleteAttribute() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
 			w.Write([]byte(err.Error()))
 			return
 		}
 
+		err = s.tree(r).DeleteAttributeContext(r.Context(), r.FormValue("name"), r.FormValue("attribute"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("ok"))
 	}
 }
 
+func (s *Server) verify() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		data, err := s.tree(r).VerifyContext(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		j, _ := json.Marshal(data)
+		w.Write([]byte(j))
+	}
+}
+
+func (s *Server) rebuild() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		err = s.tree(r).RebuildContext(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		data, err := s.tree(r).VerifyContext(r.Context())
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		j, _ := json.Marshal(data)
+		w.Write([]byte(j))
+	}
+}
+
+// queryOnly marks the requests of the handler reading the request body,
+// their parameters are read from the URL query only and the body is left to the handler
+func (s *Server) queryOnly(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		h(w, r.WithContext(context.WithValue(r.Context(), queryOnlyKey{}, true)))
+	}
+}
+
+type queryOnlyKey struct{}
+
+// param returns the request parameter name, the queryOnly requests take it from the URL query only
+func param(r *http.Request, name string) string {
+	if only, _ := r.Context().Value(queryOnlyKey{}).(bool); only {
+		return r.URL.Query().Get(name)
+	}
+	return r.FormValue(name)
+}
+
+// importNodes reads the nodes from the request body: the JSON list of the name and parent pairs by default,
+// the nested JSON roots with format=nested or CSV with format=csv. The key, tree, mode, format
+// and version parameters are read from the URL query only, the form fields of the body are not parameters
+func (s *Server) importNodes() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		key := query.Get("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		mode := treestorage.ImportMode(query.Get("mode"))
+		if mode == "" {
+			mode = treestorage.ImportMerge
+		}
+
+		body := http.MaxBytesReader(w, r.Body, _MAX_BODY_SIZE)
+		var nodes []treestorage.ImportNode
+		switch query.Get("format") {
+		case "", "pairs", "nested":
+			var data []byte
+			data, err = ioutil.ReadAll(body)
+			if err == nil {
+				nodes, err = treestorage.UnmarshalImport(data, query.Get("format") == "nested")
+			}
+		case "csv":
+			nodes, err = treestorage.ParseImportCSV(body)
+		default:
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("unknown format"))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		data, err := s.tree(r).ImportContext(r.Context(), nodes, mode)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		j, _ := json.Marshal(data)
+		w.Write([]byte(j))
+	}
+}
+
+// exportContentTypes is the content type of every export format
+var exportContentTypes = map[treestorage.ExportFormat]string{
+	treestorage.ExportDOT:     "text/vnd.graphviz; charset=utf-8",
+	treestorage.ExportMermaid: "text/plain; charset=utf-8",
+	treestorage.ExportCSV:     "text/csv; charset=utf-8",
+}
+
+func (s *Server) export() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		format := treestorage.ExportFormat(r.FormValue("format"))
+		contentType, ok := exportContentTypes[format]
+		if !ok {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid format"))
+			return
+		}
+
+		var data bytes.Buffer
+		err = s.tree(r).ExportContext(r.Context(), &data, r.FormValue("root"), format)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		w.Write(data.Bytes())
+	}
+}
+
+// batch runs the JSON array of the operations from the request body in a single transaction,
+// the results are written up to the first failed operation with its error status. The key, tree
+// and version parameters are read from the URL query only, the form fields of the body are not parameters
+func (s *Server) batch() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.URL.Query().Get("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		var ops []treestorage.BatchOp
+		err = json.NewDecoder(http.MaxBytesReader(w, r.Body, _MAX_BODY_SIZE)).Decode(&ops)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("invalid operations"))
+			return
+		}
+
+		data, err := s.tree(r).BatchContext(r.Context(), ops)
+		if err != nil && len(data) == 0 {
+			writeError(w, err)
+			return
+		}
+
+		if err != nil {
+			w.WriteHeader(errorStatus(err))
+		} else {
+			w.WriteHeader(http.StatusOK)
+		}
+		j, _ := json.Marshal(data)
+		w.Write([]byte(j))
+	}
+}
+
+// audit returns the audit records filtered by node, operation and the RFC 3339 from and to times
+func (s *Server) audit() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		key := r.FormValue("key")
+		err := s.checkKey(key)
+		if err != nil {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte(err.Error()))
+			return
+		}
+
+		filter := treestorage.AuditFilter{Node: r.FormValue("node"), Operation: r.FormValue("operation")}
+		for param, t := range map[string]*time.Time{"from": &filter.From, "to": &filter.To} {
+			if value := r.FormValue(param); value != "" {
+				*t, err = time.Parse(time.RFC3339, value)
+				if err != nil {
+					w.WriteHeader(http.StatusBadRequest)
+					w.Write([]byte("invalid " + param))
+					return
+				}
+			}
+		}
+		if limit := r.FormValue("limit"); limit != "" {
+			filter.Limit, err = strconv.Atoi(limit)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("invalid limit"))
+				return
+			}
+		}
+
+		data, err := s.tree(r).GetAuditContext(r.Context(), filter)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		j, _ := json.Marshal(data)
+		w.Write([]byte(j))
+	}
+}
+
+// readOptions reads the attributes flag and the RFC 3339 as_of time of the past tree
+func readOptions(r *http.Request) (treestorage.ReadOptions, error) {
+	opts := treestorage.ReadOptions{WithAttributes: r.FormValue("attributes") == "true"}
+	if asOf := r.FormValue("as_of"); asOf != "" {
+		var err error
+		opts.AsOf, err = time.Parse(time.RFC3339, asOf)
+		if err != nil {
+			return treestorage.ReadOptions{}, errors.New("invalid as_of")
+		}
+	}
+	return opts, nil
+}
+
+// tree returns the storage of the tree request parameter, the default tree if it is missing
+func (s *Server) tree(r *http.Request) treestorage.TreeStore {
+	return s.Storage.Tree(param(r, "tree"))
+}
+
+// writeError writes err with the http status matching the storage error
+func writeError(w http.ResponseWriter, err error) {
+	w.WriteHeader(errorStatus(err))
+	w.Write([]byte(err.Error()))
+}
+
+func errorStatus(err error) int {
+	switch {
+	case errors.Is(err, treestorage.ErrNodeNotFound),
+		errors.Is(err, treestorage.ErrParentNotFound),
+		errors.Is(err, treestorage.ErrSiblingNotFound),
+		errors.Is(err, treestorage.ErrTreeNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, treestorage.ErrNodeExists),
+		errors.Is(err, treestorage.ErrTreeExists),
+		errors.Is(err, treestorage.ErrAmbiguousName):
+		return http.StatusConflict
+	case errors.Is(err, treestorage.ErrInvalidName),
+		errors.Is(err, treestorage.ErrMoveIntoSubtree),
+		errors.Is(err, treestorage.ErrChildrenMismatch),
+		errors.Is(err, treestorage.ErrBrokenParents),
+		errors.Is(err, treestorage.ErrInvalidArgument):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, treestorage.ErrVersionMismatch):
+		return http.StatusPreconditionFailed
+	case errors.Is(err, treestorage.ErrDatabaseUnavailable):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
 func (s *Server) checkKey(key string) error {
-	if key != s.apiKeyCache {
+	if _, ok := s.apiKeys[key]; !ok {
 		return errors.New("access denied")
 	}
 	return nil
@@ -0,0 +1,32 @@
+package api
+
+import (
+	"NestedSetsStorage/treestorage"
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+)
+
+const _MAX_REQUEST_ID_LENGTH = 100 // audit.request_id is varchar(100)
+
+// audited records the API key identity and the request id to the audit log of the handler writes,
+// the request id is taken from the X-Request-ID header or generated and returned in the same header
+func (s *Server) audited(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		requestID := r.Header.Get("X-Request-ID")
+		if requestID == "" || len(requestID) > _MAX_REQUEST_ID_LENGTH {
+			requestID = newRequestID()
+		}
+		w.Header().Set("X-Request-ID", requestID)
+
+		info := treestorage.AuditInfo{Actor: s.apiKeys[param(r, "key")], RequestID: requestID}
+		h(w, r.WithContext(treestorage.WithAuditInfo(r.Context(), info)))
+	}
+}
+
+// newRequestID returns a random request id
+func newRequestID() string {
+	id := make([]byte, 16)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
@@ -0,0 +1,98 @@
+package api
+
+import (
+	"NestedSetsStorage/treestorage"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// versionWriter sets the ETag of the tree version on the successful responses
+type versionWriter struct {
+	http.ResponseWriter
+	version     func() int64 // zero means the version is unknown
+	wroteHeader bool
+}
+
+func (w *versionWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		if version := w.version(); code == http.StatusOK && version > 0 {
+			w.Header().Set("ETag", formatETag(version))
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *versionWriter) Write(data []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+// Flush sends the buffered data to the client if the wrapped writer can flush
+func (w *versionWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap returns the wrapped writer
+func (w *versionWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// tagged sets the ETag of the tree version read before the handler reads the tree,
+// so the data is never older than the version
+func (s *Server) tagged(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		// a failed read leaves the version zero, the handler reports the error itself
+		version, _ := s.tree(r).TreeVersionContext(r.Context())
+		h(&versionWriter{ResponseWriter: w, version: func() int64 { return version }}, r)
+	}
+}
+
+// versioned makes the handler writes expect the tree version of the If-Match header
+// or of the version parameter and sets the ETag of the version they produce
+func (s *Server) versioned(h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		check := &treestorage.VersionCheck{Expected: -1}
+		match := r.Header.Get("If-Match")
+		if match == "" {
+			match = param(r, "version")
+		}
+		if match != "" && match != "*" {
+			version, err := parseETag(match)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				w.Write([]byte("invalid version"))
+				return
+			}
+			check.Expected = version
+		}
+
+		r = r.WithContext(treestorage.WithVersionCheck(r.Context(), check))
+		h(&versionWriter{ResponseWriter: w, version: func() int64 { return check.Committed }}, r)
+	}
+}
+
+func formatETag(version int64) string {
+	return strconv.Quote(strconv.FormatInt(version, 10))
+}
+
+// parseETag accepts the quoted ETag or the bare version number
+func parseETag(tag string) (int64, error) {
+	tag = strings.TrimSpace(tag)
+	if unquoted, err := strconv.Unquote(tag); err == nil {
+		tag = unquoted
+	}
+	version, err := strconv.ParseInt(tag, 10, 64)
+	if err != nil || version < 0 {
+		return 0, strconv.ErrSyntax
+	}
+	return version, nil
+}
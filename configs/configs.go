@@ -4,6 +4,18 @@ package configs
 type Config struct {
 	DbConnectionSting string `toml:"db_connection_string"`
 	DbDriver          string `toml:"db_driver"`
+	DbMaxOpenConns    int    `toml:"db_max_open_conns"`
+	DbMaxIdleConns    int    `toml:"db_max_idle_conns"`
+	DbConnMaxLifetime int    `toml:"db_conn_max_lifetime"` // seconds
+	DbDefaultTimeout  int    `toml:"db_default_timeout"`   // milliseconds
 	APIPort           string `toml:"api_port"`
-	APIKey            string `toml:"api_key"`
+	APIKey            string `toml:"api_key"` // the key of the "default" identity
+	PathSeparator     string `toml:"path_separator"`
+	NameUniqueness    string `toml:"name_uniqueness"` // global, per_parent or none, applied to the created trees
+
+	// APIKeys is the keys of the named identities recorded to the audit log, keyed by identity
+	APIKeys map[string]string `toml:"api_keys"`
+
+	// DbTimeouts is the per-operation timeouts in milliseconds, keyed by treestorage operation names
+	DbTimeouts map[string]int `toml:"db_timeouts"`
 }
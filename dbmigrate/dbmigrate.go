@@ -35,162 +35,786 @@ func queriesForCreatingDb() []string {
 			PRIMARY KEY (id)
 		);`,
 
-		`CREATE INDEX IF NOT EXISTS index_left ON nodes (node_left);`,
-		`CREATE INDEX IF NOT EXISTS index_right ON nodes (node_right);`,
+		`CREATE TABLE IF NOT EXISTS trees
+		(
+			id SERIAL,
+			name VARCHAR(100) NOT NULL UNIQUE,
+			PRIMARY KEY (id)
+		);`,
+
+		`ALTER TABLE trees ADD COLUMN IF NOT EXISTS name_uniqueness VARCHAR(10) NOT NULL DEFAULT 'global';`,
+
+		// the version grows with every change of the tree nodes
+		`ALTER TABLE trees ADD COLUMN IF NOT EXISTS version BIGINT NOT NULL DEFAULT 1;`,
+
+		`INSERT INTO trees (name) VALUES ('default') ON CONFLICT (name) DO NOTHING;`,
+
+		// the nodes created before the trees belong to the default tree
+		`ALTER TABLE nodes ADD COLUMN IF NOT EXISTS tree_id INT REFERENCES trees (id) ON DELETE CASCADE;`,
+		`UPDATE nodes SET tree_id = (SELECT id FROM trees WHERE name = 'default') WHERE tree_id IS NULL;`,
+		`ALTER TABLE nodes ALTER COLUMN tree_id SET NOT NULL;`,
+
+		`ALTER TABLE nodes ADD COLUMN IF NOT EXISTS attributes JSONB NOT NULL DEFAULT '{}';`,
+
+		// the parent ids of the existing nodes are taken from their intervals once
+		`DO $$
+		BEGIN
+			IF NOT EXISTS (SELECT 1 FROM information_schema.columns
+							WHERE table_name = 'nodes' AND column_name = 'parent_id') THEN
+
+				ALTER TABLE nodes ADD COLUMN parent_id INT REFERENCES nodes (id) ON DELETE SET NULL;
+
+				UPDATE nodes AS n
+				SET parent_id = (SELECT p.id FROM nodes AS p
+								WHERE p.tree_id = n.tree_id AND p.node_left < n.node_left AND p.node_right > n.node_right
+								ORDER BY p.node_left DESC
+								LIMIT 1);
+
+			END IF;
+		END;
+		$$`,
+		`CREATE INDEX IF NOT EXISTS index_parent ON nodes (parent_id);`,
 
-		`CREATE OR REPLACE FUNCTION increase_nodes_left ( range_start INT, range_finish INT, value INT) 
+		// the name uniqueness depends on the tree and is checked by the functions
+		`ALTER TABLE nodes DROP CONSTRAINT IF EXISTS nodes_name_key;`,
+		`DROP INDEX IF EXISTS index_tree_name;`,
+		`CREATE INDEX IF NOT EXISTS index_tree_node_name ON nodes (tree_id, name);`,
+
+		`DROP INDEX IF EXISTS index_left;`,
+		`DROP INDEX IF EXISTS index_right;`,
+		`CREATE INDEX IF NOT EXISTS index_tree_left ON nodes (tree_id, node_left);`,
+		`CREATE INDEX IF NOT EXISTS index_tree_right ON nodes (tree_id, node_right);`,
+
+		// the changes of the trees are recorded in their transactions,
+		// the records keep the tree name to outlive the deleted trees
+		`CREATE TABLE IF NOT EXISTS audit
+		(
+			id BIGSERIAL,
+			tree VARCHAR(100) NOT NULL,
+			operation VARCHAR(30) NOT NULL,
+			node VARCHAR(100) NOT NULL DEFAULT '',
+			node_id INT,
+			arguments JSONB NOT NULL DEFAULT '{}',
+			position_before JSONB,
+			position_after JSONB,
+			actor VARCHAR(100) NOT NULL DEFAULT '',
+			request_id VARCHAR(100) NOT NULL DEFAULT '',
+			created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+			PRIMARY KEY (id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS index_audit_tree_time ON audit (tree, created_at);`,
+		`CREATE INDEX IF NOT EXISTS index_audit_tree_node ON audit (tree, node);`,
+
+		// every version of a node is valid from the change making it to the next one,
+		// a version keeps the place of the node among the siblings and not its left and right
+		`CREATE TABLE IF NOT EXISTS nodes_history
+		(
+			id BIGSERIAL,
+			tree_id INT NOT NULL REFERENCES trees (id) ON DELETE CASCADE,
+			node_id INT NOT NULL,
+			parent_id INT,
+			rank INT NOT NULL,
+			name VARCHAR(100) NOT NULL,
+			attributes JSONB NOT NULL DEFAULT '{}',
+			valid_from TIMESTAMPTZ NOT NULL,
+			valid_to TIMESTAMPTZ,
+			PRIMARY KEY (id)
+		);`,
+		`CREATE INDEX IF NOT EXISTS index_history_tree_time ON nodes_history (tree_id, valid_from, valid_to);`,
+		`CREATE INDEX IF NOT EXISTS index_history_current ON nodes_history (node_id) WHERE valid_to IS NULL;`,
+		`CREATE INDEX IF NOT EXISTS index_history_current_parent ON nodes_history (tree_id, parent_id) WHERE valid_to IS NULL;`,
+
+		`CREATE OR REPLACE FUNCTION record_history (tree INT, parent_ids INT[]) 
+		RETURNS VOID AS $$
+		DECLARE
+			changed_at TIMESTAMPTZ;
+		BEGIN
+
+			-- the storage sets the time of its changes once the tree lock is taken,
+			-- the other changes are made at the transaction start
+			changed_at := COALESCE(NULLIF(current_setting('treestorage.changed_at', true), '')::TIMESTAMPTZ, now());
+
+			-- the parent id 0 stands for the roots and NULL parent ids for every parent of the tree
+			IF parent_ids IS NULL THEN
+				SELECT array_agg(p.id)
+				INTO parent_ids
+				FROM (SELECT COALESCE(n.parent_id, 0) FROM nodes AS n WHERE n.tree_id = tree
+					UNION
+					SELECT COALESCE(h.parent_id, 0) FROM nodes_history AS h
+					WHERE h.tree_id = tree AND h.valid_to IS NULL) AS p(id);
+			END IF;
+
+			-- the children of the parents are versioned with their rank among the siblings
+			WITH current_nodes AS (
+				SELECT n.id, n.parent_id, n.name, n.attributes,
+					ROW_NUMBER() OVER (PARTITION BY n.parent_id ORDER BY n.node_left) AS rank
+				FROM nodes AS n
+				WHERE n.tree_id = tree
+					AND (n.parent_id = ANY(parent_ids) OR (n.parent_id IS NULL AND 0 = ANY(parent_ids)))),
+			stale AS (
+				SELECT h.id, h.valid_from
+				FROM nodes_history AS h
+				WHERE h.tree_id = tree AND h.valid_to IS NULL
+					AND (h.parent_id = ANY(parent_ids) OR (h.parent_id IS NULL AND 0 = ANY(parent_ids)))
+					AND NOT EXISTS (SELECT 1 FROM current_nodes AS c
+									WHERE c.id = h.node_id AND c.parent_id IS NOT DISTINCT FROM h.parent_id
+										AND c.rank = h.rank AND c.name = h.name AND c.attributes = h.attributes)),
+			-- a version made earlier by the same change is replaced
+			replaced AS (
+				DELETE FROM nodes_history AS h
+				USING stale AS s
+				WHERE h.id = s.id AND s.valid_from = changed_at),
+			closed AS (
+				UPDATE nodes_history AS h
+				SET valid_to = changed_at
+				FROM stale AS s
+				WHERE h.id = s.id AND s.valid_from <> changed_at)
+			INSERT INTO nodes_history
+			(tree_id, node_id, parent_id, rank, name, attributes, valid_from)
+			SELECT tree, c.id, c.parent_id, c.rank, c.name, c.attributes, changed_at
+			FROM current_nodes AS c
+			WHERE NOT EXISTS (SELECT 1 FROM nodes_history AS h
+							WHERE h.node_id = c.id AND h.valid_to IS NULL
+								AND c.parent_id IS NOT DISTINCT FROM h.parent_id
+								AND c.rank = h.rank AND c.name = h.name AND c.attributes = h.attributes);
+		END;
+		$$  LANGUAGE plpgsql`,
+
+		`CREATE OR REPLACE FUNCTION record_nodes_history () 
+		RETURNS TRIGGER AS $$
+		BEGIN
+
+			-- the children are versioned again when their parent gains or loses a node
+			-- or one of them is renamed or gets other attributes, the left and right shifts are not versioned,
+			-- the functions changing the order of the siblings record it themselves
+			IF TG_OP = 'INSERT' THEN
+				PERFORM record_history(n.tree_id, array_agg(DISTINCT COALESCE(n.parent_id, 0)))
+				FROM new_nodes AS n
+				GROUP BY n.tree_id;
+			ELSEIF TG_OP = 'DELETE' THEN
+				PERFORM record_history(o.tree_id, array_agg(DISTINCT COALESCE(o.parent_id, 0)))
+				FROM old_nodes AS o
+				GROUP BY o.tree_id;
+			ELSE
+				PERFORM record_history(n.tree_id, array_agg(DISTINCT p.id))
+				FROM old_nodes AS o JOIN new_nodes AS n ON n.id = o.id,
+					unnest(ARRAY[COALESCE(o.parent_id, 0), COALESCE(n.parent_id, 0)]) AS p(id)
+				WHERE o.parent_id IS DISTINCT FROM n.parent_id OR o.name <> n.name OR o.attributes <> n.attributes
+				GROUP BY n.tree_id;
+			END IF;
+
+			RETURN NULL;
+		END;
+		$$  LANGUAGE plpgsql`,
+		`DROP TRIGGER IF EXISTS record_history_insert ON nodes;`,
+		`CREATE TRIGGER record_history_insert AFTER INSERT ON nodes
+		REFERENCING NEW TABLE AS new_nodes
+		FOR EACH STATEMENT EXECUTE PROCEDURE record_nodes_history();`,
+		`DROP TRIGGER IF EXISTS record_history_update ON nodes;`,
+		`CREATE TRIGGER record_history_update AFTER UPDATE ON nodes
+		REFERENCING OLD TABLE AS old_nodes NEW TABLE AS new_nodes
+		FOR EACH STATEMENT EXECUTE PROCEDURE record_nodes_history();`,
+		`DROP TRIGGER IF EXISTS record_history_delete ON nodes;`,
+		`CREATE TRIGGER record_history_delete AFTER DELETE ON nodes
+		REFERENCING OLD TABLE AS old_nodes
+		FOR EACH STATEMENT EXECUTE PROCEDURE record_nodes_history();`,
+
+		// the history is reconciled with the nodes changed while it was not recorded
+		`SELECT record_history(id, NULL) FROM trees;`,
+
+		// the nodes valid at the time are numbered like rebuild_tree numbers them
+		`CREATE OR REPLACE FUNCTION nodes_at (tree_name varchar(100), at TIMESTAMPTZ) 
+		RETURNS TABLE (id INT, tree_id INT, name VARCHAR(100), node_left INT, node_right INT,
+			parent_id INT, attributes JSONB) AS $$
+			WITH RECURSIVE versions AS (
+				SELECT h.node_id, h.tree_id, h.parent_id, h.rank, h.name, h.attributes
+				FROM trees AS t JOIN nodes_history AS h ON h.tree_id = t.id
+				WHERE t.name = tree_name AND h.valid_from <= at AND (h.valid_to IS NULL OR h.valid_to > at)),
+			walk AS (
+				SELECT v.node_id, ARRAY[v.rank] AS path, ARRAY[v.node_id] AS ancestors
+				FROM versions AS v
+				WHERE v.parent_id IS NULL
+				UNION ALL
+				SELECT v.node_id, w.path || v.rank, w.ancestors || v.node_id
+				FROM versions AS v JOIN walk AS w ON v.parent_id = w.node_id),
+			ordered AS (
+				SELECT w.node_id, array_length(w.path, 1) - 1 AS depth,
+					ROW_NUMBER() OVER (ORDER BY w.path) - 1 AS preorder
+				FROM walk AS w),
+			sizes AS (
+				SELECT a.id, COUNT(*) - 1 AS descendants
+				FROM walk AS w, unnest(w.ancestors) AS a(id)
+				GROUP BY a.id)
+			SELECT v.node_id, v.tree_id, v.name,
+				(2 * o.preorder - o.depth)::INT, (2 * o.preorder - o.depth + 2 * s.descendants + 1)::INT,
+				v.parent_id, v.attributes
+			FROM versions AS v JOIN ordered AS o ON o.node_id = v.node_id JOIN sizes AS s ON s.id = v.node_id;
+		$$  LANGUAGE sql STABLE`,
+
+		// superseded function signatures
+		`DROP FUNCTION IF EXISTS remove_node(varchar);`,
+		`DROP FUNCTION IF EXISTS add_node(varchar, varchar);`,
+		`DROP FUNCTION IF EXISTS move_node(varchar, varchar);`,
+		`DROP FUNCTION IF EXISTS move_subtree(varchar, varchar);`,
+		`DROP FUNCTION IF EXISTS increase_nodes_left(int, int, int);`,
+		`DROP FUNCTION IF EXISTS increase_nodes_right(int, int, int);`,
+		`DROP FUNCTION IF EXISTS move_range(int, int, int);`,
+		`DROP FUNCTION IF EXISTS remove_subtree(varchar);`,
+		`DROP FUNCTION IF EXISTS reassign_children(varchar, varchar);`,
+		`DROP FUNCTION IF EXISTS child_boundary(int, int, varchar, varchar);`,
+		`DROP FUNCTION IF EXISTS add_node(varchar, varchar, varchar, varchar);`,
+		`DROP FUNCTION IF EXISTS move_node(varchar, varchar, varchar, varchar);`,
+		`DROP FUNCTION IF EXISTS move_subtree(varchar, varchar, varchar, varchar);`,
+		`DROP FUNCTION IF EXISTS reorder_children(varchar, varchar[]);`,
+
+		// add_node returns the id of the added node with the result code
+		`DO $$
+		BEGIN
+			IF EXISTS (SELECT 1 FROM pg_proc
+						WHERE proname = 'add_node' AND prorettype = 'int4'::regtype) THEN
+				DROP FUNCTION add_node(INT, varchar, varchar, varchar, varchar);
+			END IF;
+		END;
+		$$`,
+
+		`CREATE OR REPLACE FUNCTION resolve_node (tree INT, node_ref varchar(100)) 
+		RETURNS INT AS $$
+		DECLARE
+			found_id INT;
+			found_count INT;
+		BEGIN
+
+			-- "id:<n>" refers to the node id, anything else is the node name,
+			-- NULL means no node and 0 means the name is not unique in the tree
+			IF node_ref ~ '^id:[0-9]{1,9}$' THEN
+				SELECT id
+				INTO found_id
+				FROM nodes
+				WHERE tree_id = tree AND id = substring(node_ref FROM 4)::INT;
+
+				RETURN found_id;
+			END IF;
+
+			SELECT MIN(id), COUNT(*)
+			INTO found_id, found_count
+			FROM nodes
+			WHERE tree_id = tree AND name = node_ref;
+
+			IF found_count > 1 THEN
+				RETURN 0;
+			END IF;
+			RETURN found_id;
+		END;
+		$$  LANGUAGE plpgsql STABLE`,
+
+		`CREATE OR REPLACE FUNCTION resolve_node_at (tree INT, node_ref varchar(100), at TIMESTAMPTZ) 
+		RETURNS INT AS $$
+		DECLARE
+			found_id INT;
+			found_count INT;
+		BEGIN
+
+			-- resolve_node among the node versions valid at the time
+			IF node_ref ~ '^id:[0-9]{1,9}$' THEN
+				SELECT node_id
+				INTO found_id
+				FROM nodes_history
+				WHERE tree_id = tree AND node_id = substring(node_ref FROM 4)::INT
+					AND valid_from <= at AND (valid_to IS NULL OR valid_to > at);
+
+				RETURN found_id;
+			END IF;
+
+			SELECT MIN(node_id), COUNT(*)
+			INTO found_id, found_count
+			FROM nodes_history
+			WHERE tree_id = tree AND name = node_ref
+				AND valid_from <= at AND (valid_to IS NULL OR valid_to > at);
+
+			IF found_count > 1 THEN
+				RETURN 0;
+			END IF;
+			RETURN found_id;
+		END;
+		$$  LANGUAGE plpgsql STABLE`,
+
+		`CREATE OR REPLACE FUNCTION name_taken (tree INT, node_id INT, node_name varchar(100), 
+			parent_left INT, parent_right INT) 
+		RETURNS BOOLEAN AS $$
+		DECLARE
+			uniqueness varchar(10);
+		BEGIN
+
+			-- whether another node of the tree holds node_name in the scope of the tree name uniqueness,
+			-- the parent range of the roots is (-1, 2147483647)
+			SELECT name_uniqueness
+			INTO uniqueness
+			FROM trees
+			WHERE id = tree;
+
+			IF uniqueness = 'global' THEN
+				RETURN EXISTS (SELECT 1 FROM nodes
+								WHERE tree_id = tree AND name = node_name AND id IS DISTINCT FROM node_id);
+			ELSEIF uniqueness = 'per_parent' THEN
+				RETURN EXISTS (SELECT 1 FROM nodes AS c
+								WHERE c.tree_id = tree AND c.name = node_name AND c.id IS DISTINCT FROM node_id
+									AND c.node_left > parent_left AND c.node_right < parent_right
+									AND NOT EXISTS (SELECT 1 FROM nodes AS a
+													WHERE a.tree_id = tree AND a.node_left > parent_left
+														AND a.node_left < c.node_left AND a.node_right > c.node_right));
+			END IF;
+
+			RETURN FALSE;
+		END;
+		$$  LANGUAGE plpgsql STABLE`,
+
+		`CREATE OR REPLACE FUNCTION parent_range (tree INT, node_left INT, node_right INT) 
+		RETURNS TABLE (parent_left INT, parent_right INT) AS $$
+		BEGIN
+
+			-- the range of the nearest ancestor, the roots get (-1, 2147483647)
+			RETURN QUERY
+			SELECT COALESCE(MAX(p.node_left), -1), COALESCE(MIN(p.node_right), 2147483647)
+			FROM nodes AS p
+			WHERE p.tree_id = tree AND p.node_left < parent_range.node_left AND p.node_right > parent_range.node_right;
+
+		END;
+		$$  LANGUAGE plpgsql STABLE`,
+
+		`CREATE OR REPLACE FUNCTION promote_conflict (tree INT, node_id INT) 
+		RETURNS BOOLEAN AS $$
+		DECLARE
+			node RECORD;
+			parent RECORD;
+		BEGIN
+
+			-- whether a child of the node takes a name of the node siblings when it replaces the node,
+			-- the promoted children keep their names unique in the other modes
+			IF (SELECT name_uniqueness FROM trees WHERE id = tree) <> 'per_parent' THEN
+				RETURN FALSE;
+			END IF;
+
+			SELECT node_left, node_right
+			INTO node
+			FROM nodes
+			WHERE id = node_id;
+
+			SELECT *
+			INTO parent
+			FROM parent_range(tree, node.node_left, node.node_right);
+
+			RETURN EXISTS (SELECT 1 FROM nodes AS c
+							WHERE c.tree_id = tree AND c.node_left > node.node_left AND c.node_right < node.node_right
+								AND NOT EXISTS (SELECT 1 FROM nodes AS a
+												WHERE a.tree_id = tree AND a.node_left > node.node_left
+													AND a.node_left < c.node_left AND a.node_right > c.node_right)
+								AND name_taken(tree, node_id, c.name, parent.parent_left, parent.parent_right));
+		END;
+		$$  LANGUAGE plpgsql STABLE`,
+
+		`CREATE OR REPLACE FUNCTION increase_nodes_left (tree INT, range_start INT, range_finish INT, value INT) 
 		RETURNS VOID AS $$
 		BEGIN
 
 			UPDATE nodes 
 			SET node_left = node_left + value
-			WHERE range_start < node_left AND node_left < range_finish;
+			WHERE tree_id = tree AND range_start < node_left AND node_left < range_finish;
 
 		END;
 		$$  LANGUAGE plpgsql`,
 
-		`CREATE OR REPLACE FUNCTION increase_nodes_right ( range_start INT, range_finish INT, value INT) 
+		`CREATE OR REPLACE FUNCTION increase_nodes_right (tree INT, range_start INT, range_finish INT, value INT) 
 		RETURNS VOID AS $$
 		BEGIN
 
 			UPDATE nodes 
 			SET node_right = node_right + value
-			WHERE range_start < node_right AND node_right < range_finish;
+			WHERE tree_id = tree AND range_start < node_right AND node_right < range_finish;
 
 		END;
 		$$  LANGUAGE plpgsql`,
 
-		`CREATE OR REPLACE FUNCTION remove_node (node_name varchar(100)) 
-		RETURNS varchar(50) AS $$
+		`CREATE OR REPLACE FUNCTION move_range (tree INT, range_left INT, range_right INT, target INT) 
+		RETURNS VOID AS $$
 		DECLARE
+			width INT := range_right - range_left + 1;
+			shift INT;
+			gap_start INT;
+			gap_finish INT;
+			gap_shift INT;
+		BEGIN
+
+			-- the range is placed just before the target boundary,
+			-- the nodes between the range and the target close the gap
+			IF target > range_right + 1 THEN
+				shift := target - range_right - 1;
+				gap_start := range_right + 1;
+				gap_finish := target - 1;
+				gap_shift := -width;
+			ELSEIF target < range_left THEN
+				shift := target - range_left;
+				gap_start := target;
+				gap_finish := range_left - 1;
+				gap_shift := width;
+			ELSE
+				RETURN;
+			END IF;
+
+			UPDATE nodes 
+			SET node_left = CASE
+					WHEN node_left BETWEEN range_left AND range_right THEN node_left + shift
+					WHEN node_left BETWEEN gap_start AND gap_finish THEN node_left + gap_shift
+					ELSE node_left END,
+				node_right = CASE
+					WHEN node_right BETWEEN range_left AND range_right THEN node_right + shift
+					WHEN node_right BETWEEN gap_start AND gap_finish THEN node_right + gap_shift
+					ELSE node_right END
+			WHERE tree_id = tree
+				AND (node_left BETWEEN LEAST(range_left, gap_start) AND GREATEST(range_right, gap_finish)
+					OR node_right BETWEEN LEAST(range_left, gap_start) AND GREATEST(range_right, gap_finish));
+
+		END;
+		$$  LANGUAGE plpgsql`,
+
+		`CREATE OR REPLACE FUNCTION remove_node (tree INT, node_name varchar(100)) 
+		RETURNS INT AS $$
+		DECLARE
+			node_id INT := resolve_node(tree, node_name);
 			node RECORD;
-			result varchar(50) := '';
+			result INT := 0; -- see treestorage result codes
 		BEGIN	
 
-			SELECT node_left, node_right, name
+			SELECT id, node_left, node_right, name
 			INTO node
 			FROM nodes
 			WHERE 
-				name = node_name;
+				id = node_id;
 
-			IF node IS NOT NULL THEN
+			IF node_id = 0 THEN
+				result := 7; -- ambiguous name
+			ELSEIF node IS NOT NULL AND promote_conflict(tree, node.id) THEN
+				result := 3; -- node already exists
+			ELSEIF node IS NOT NULL THEN
 
-				PERFORM increase_nodes_left(node.node_left, node.node_right, -1);
-				PERFORM increase_nodes_right(node.node_left, node.node_right, -1);
+				PERFORM increase_nodes_left(tree, node.node_left, node.node_right, -1);
+				PERFORM increase_nodes_right(tree, node.node_left, node.node_right, -1);
 
 				UPDATE nodes 
 				SET node_left = node_left -2
-				WHERE node_left > node.node_right;
+				WHERE tree_id = tree AND node_left > node.node_right;
 
 				UPDATE nodes 
 				SET node_right = node_right -2
-				WHERE node_right > node.node_right;
+				WHERE tree_id = tree AND node_right > node.node_right;
+
+				-- the children take the place of the node
+				UPDATE nodes
+				SET parent_id = (SELECT p.parent_id FROM nodes AS p WHERE p.id = node.id)
+				WHERE parent_id = node.id;
 
 				DELETE FROM nodes
-				WHERE name = node.name;
+				WHERE id = node.id;
 
 			ELSE
-				result := 'remove fail: node not found';
+				result := 1; -- node not found
 			END IF;
 
 			RETURN result;
 		END;
 		$$  LANGUAGE plpgsql`,
 
-		`CREATE OR REPLACE FUNCTION add_node (node_name varchar(100), parent_name varchar(100)) 
-		RETURNS varchar(50) AS $$
+		`CREATE OR REPLACE FUNCTION remove_subtree (tree INT, node_name varchar(100)) 
+		RETURNS INT AS $$
 		DECLARE
-			parent RECORD;
+			node_id INT := resolve_node(tree, node_name);
 			node RECORD;
-			result varchar(50) := '';
+			width INT;
+			result INT := 0; -- see treestorage result codes
 		BEGIN	
 
 			SELECT node_left, node_right
-			INTO parent
+			INTO node
 			FROM nodes
 			WHERE 
-				name = parent_name;
+				id = node_id;
+
+			IF node_id = 0 THEN
+				result := 7; -- ambiguous name
+			ELSEIF node IS NOT NULL THEN
+
+				DELETE FROM nodes
+				WHERE tree_id = tree AND node_left >= node.node_left AND node_right <= node.node_right;
+
+				width := node.node_right - node.node_left + 1;
+
+				UPDATE nodes 
+				SET node_left = node_left - width
+				WHERE tree_id = tree AND node_left > node.node_right;
+
+				UPDATE nodes 
+				SET node_right = node_right - width
+				WHERE tree_id = tree AND node_right > node.node_right;
+
+			ELSE
+				result := 1; -- node not found
+			END IF;
+
+			RETURN result;
+		END;
+		$$  LANGUAGE plpgsql`,
+
+		`CREATE OR REPLACE FUNCTION reassign_children (tree INT, node_name varchar(100), target_name varchar(100)) 
+		RETURNS INT AS $$
+		DECLARE
+			node_id INT := resolve_node(tree, node_name);
+			target_id INT := resolve_node(tree, target_name);
+			node RECORD;
+			target RECORD;
+			child RECORD;
+			moved RECORD;
+			result INT := 0; -- see treestorage result codes
+		BEGIN	
 
-			SELECT name
+			SELECT node_left, node_right
 			INTO node
 			FROM nodes
 			WHERE 
-				name = node_name;
+				id = node_id;
+
+			SELECT node_left, node_right
+			INTO target
+			FROM nodes
+			WHERE 
+				id = target_id;
+
+			IF node_id = 0 OR target_id = 0 THEN
+				result := 7; -- ambiguous name
+			ELSEIF node IS NULL THEN
+				result := 1; -- node not found
+			ELSEIF target IS NULL THEN
+				result := 2; -- parent not found
+			ELSEIF target.node_left >= node.node_left AND target.node_right <= node.node_right THEN
+				result := 4; -- move into own subtree
+			ELSE
+
+				-- direct children in their order, each one becomes the last child of the target
+				FOR child IN
+					SELECT c.id, c.name
+					FROM nodes AS c
+					WHERE c.tree_id = tree AND c.node_left > node.node_left AND c.node_right < node.node_right
+						AND NOT EXISTS (SELECT 1 FROM nodes AS a
+										WHERE a.tree_id = tree AND a.node_left > node.node_left
+											AND a.node_left < c.node_left AND a.node_right > c.node_right)
+					ORDER BY c.node_left
+				LOOP
+					SELECT node_left, node_right
+					INTO moved
+					FROM nodes
+					WHERE id = child.id;
+
+					SELECT node_left, node_right
+					INTO target
+					FROM nodes
+					WHERE id = target_id;
+
+					IF name_taken(tree, child.id, child.name, target.node_left, target.node_right) THEN
+						RETURN 3; -- node already exists
+					END IF;
+
+					PERFORM move_range(tree, moved.node_left, moved.node_right, target.node_right);
+
+					UPDATE nodes
+					SET parent_id = target_id
+					WHERE id = child.id;
+				END LOOP;
+
+				result := remove_node(tree, 'id:' || node_id);
+			END IF;
+
+			RETURN result;
+		END;
+		$$  LANGUAGE plpgsql`,
+
+		`CREATE OR REPLACE FUNCTION child_boundary (tree INT, parent_left INT, parent_right INT, 
+			pos_kind varchar(10), sibling_name varchar(100)) 
+		RETURNS INT AS $$
+		DECLARE
+			sibling RECORD;
+		BEGIN
+
+			-- the boundary a new child is placed at, NULL if the sibling is not a child of the parent,
+			-- -1 if several children have the sibling name
+			IF pos_kind = 'first' THEN
+				RETURN parent_left + 1;
+			ELSEIF pos_kind = 'before' OR pos_kind = 'after' THEN
+
+				SELECT MIN(sb.node_left) AS node_left, MIN(sb.node_right) AS node_right, COUNT(*) AS found
+				INTO sibling
+				FROM nodes AS sb
+				WHERE 
+					sb.tree_id = tree AND (sb.name = sibling_name OR 'id:' || sb.id = sibling_name)
+					AND sb.node_left > parent_left AND sb.node_right < parent_right
+					AND NOT EXISTS (SELECT 1 FROM nodes AS a
+									WHERE a.tree_id = tree AND a.node_left > parent_left
+										AND a.node_left < sb.node_left AND a.node_right > sb.node_right);
+
+				IF sibling.found = 0 THEN
+					RETURN NULL;
+				ELSEIF sibling.found > 1 THEN
+					RETURN -1;
+				ELSEIF pos_kind = 'before' THEN
+					RETURN sibling.node_left;
+				END IF;
+				RETURN sibling.node_right + 1;
+
+			END IF;
+
+			RETURN parent_right;
+		END;
+		$$  LANGUAGE plpgsql`,
+
+		`CREATE OR REPLACE FUNCTION add_node (tree INT, node_name varchar(100), parent_name varchar(100), 
+			pos_kind varchar(10), sibling_name varchar(100), 
+			OUT result INT, -- see treestorage result codes
+			OUT node_id INT) 
+		AS $$
+		DECLARE
+			parent_node_id INT := resolve_node(tree, parent_name);
+			parent RECORD;
+			boundary INT;
+		BEGIN	
+			result := 0;
+
+			SELECT node_left, node_right
+			INTO parent
+			FROM nodes
+			WHERE 
+				id = parent_node_id;
 
-			IF parent IS NOT NULL AND node IS NULL THEN
+			IF parent IS NOT NULL THEN
+				boundary := child_boundary(tree, parent.node_left, parent.node_right, pos_kind, sibling_name);
+			END IF;
+
+			IF parent_node_id = 0 OR boundary = -1 THEN
+				result := 7; -- ambiguous name
+			ELSEIF parent IS NULL THEN
+				result := 2; -- parent not found
+			ELSEIF name_taken(tree, NULL, node_name, parent.node_left, parent.node_right) THEN
+				result := 3; -- node already exists
+			ELSEIF boundary IS NULL THEN
+				result := 5; -- sibling not found
+			ELSE
 
 				UPDATE nodes 
 				SET node_left = node_left +2
-				WHERE node_left >= parent.node_right;
+				WHERE tree_id = tree AND node_left >= boundary;
 
 				UPDATE nodes 
 				SET node_right = node_right +2
-				WHERE node_right >= parent.node_right;
+				WHERE tree_id = tree AND node_right >= boundary;
 
 				INSERT INTO nodes
-				(name, node_left, node_right) 
-				VALUES (node_name, parent.node_right, parent.node_right + 1);
+				(tree_id, parent_id, name, node_left, node_right) 
+				VALUES (tree, parent_node_id, node_name, boundary, boundary + 1)
+				RETURNING id INTO node_id;
 
-			ELSE
-				result := 'add fail: parent not found or node already exists';
 			END IF;
-
-			RETURN result;
 		END;
 		$$  LANGUAGE plpgsql`,
 
-		`CREATE OR REPLACE FUNCTION move_node (node_name varchar(100), parent_name varchar(100)) 
-		RETURNS varchar(50) AS $$
+		`CREATE OR REPLACE FUNCTION move_node (tree INT, node_name varchar(100), parent_name varchar(100), 
+			pos_kind varchar(10), sibling_name varchar(100)) 
+		RETURNS INT AS $$
 		DECLARE
+			node_id INT := resolve_node(tree, node_name);
+			parent_node_id INT := resolve_node(tree, parent_name);
 			node RECORD;
 			parent RECORD;
-			result varchar(50) := '';
+			boundary INT;
+			result INT := 0; -- see treestorage result codes
 		BEGIN
-			SELECT node_left, node_right, name
+			SELECT id, node_left, node_right, name
 			INTO node
 			FROM nodes
 			WHERE 
-				name = node_name;
+				id = node_id;
 
-			SELECT node_left, node_right, name 
+			SELECT id, node_left, node_right, name 
 			INTO parent
 			FROM nodes
 			WHERE 
-				name = parent_name;
+				id = parent_node_id;
+
+			IF parent IS NOT NULL THEN
+				boundary := child_boundary(tree, parent.node_left, parent.node_right, pos_kind, sibling_name);
+			END IF;
+
+			IF node_id = 0 OR parent_node_id = 0 OR boundary = -1 THEN
+				result := 7; -- ambiguous name
+			ELSEIF node IS NULL THEN
+				result := 1; -- node not found
+			ELSEIF parent IS NULL THEN
+				result := 2; -- parent not found
+			ELSEIF node.id = parent.id THEN
+				result := 4; -- move into own subtree
+			ELSEIF boundary IS NULL
+				OR (pos_kind IN ('before', 'after') AND sibling_name IN (node.name, 'id:' || node.id)) THEN
+				result := 5; -- sibling not found
+			ELSEIF name_taken(tree, node.id, node.name, parent.node_left, parent.node_right)
+				OR promote_conflict(tree, node.id) THEN
+				result := 3; -- node already exists
+			ELSEIF pos_kind <> '' THEN
+
+				-- the node children take its place, the node becomes a leaf after them
+				PERFORM increase_nodes_left(tree, node.node_left, node.node_right, -1);
+				PERFORM increase_nodes_right(tree, node.node_left, node.node_right, -1);
 
-			IF node IS NOT NULL and parent IS NOT NULL THEN
+				UPDATE nodes 
+				SET node_left = node.node_right - 1
+				WHERE id = node.id;
+
+				SELECT node_left, node_right
+				INTO parent
+				FROM nodes
+				WHERE 
+					id = parent_node_id;
+
+				boundary := child_boundary(tree, parent.node_left, parent.node_right, pos_kind, sibling_name);
+				PERFORM move_range(tree, node.node_right - 1, node.node_right, boundary);
+
+			ELSE
 
 				/* * * * * * * * * * * * * * * * * * * *
 				* right moving to the left parent edge
 				* * * * * * * * * * * * * * * * * * * */
 				IF node.node_right < parent.node_left THEN
 
-					PERFORM increase_nodes_left(node.node_left, node.node_right, -1);
-					PERFORM increase_nodes_right(node.node_left, node.node_right, -1);
+					PERFORM increase_nodes_left(tree, node.node_left, node.node_right, -1);
+					PERFORM increase_nodes_right(tree, node.node_left, node.node_right, -1);
 
-					PERFORM increase_nodes_left(node.node_right, parent.node_left + 1, -2);
-					PERFORM increase_nodes_right(node.node_right, parent.node_left + 1, -2);
+					PERFORM increase_nodes_left(tree, node.node_right, parent.node_left + 1, -2);
+					PERFORM increase_nodes_right(tree, node.node_right, parent.node_left + 1, -2);
 
 					UPDATE nodes 
 					SET node_left = parent.node_left - 1,
 					node_right = parent.node_left
-					WHERE name = node.name;
+					WHERE id = node.id;
 
 				/* * * * * * * * * * * * * * * * * * * *
 				* left moving to the right parent edge
 				* * * * * * * * * * * * * * * * * * * */
 				ELSEIF node.node_left > parent.node_right THEN
 
-					PERFORM increase_nodes_left(node.node_left, node.node_right, 1);
-					PERFORM increase_nodes_right(node.node_left, node.node_right, 1);
+					PERFORM increase_nodes_left(tree, node.node_left, node.node_right, 1);
+					PERFORM increase_nodes_right(tree, node.node_left, node.node_right, 1);
 
-					PERFORM increase_nodes_left(parent.node_right - 1, node.node_left, 2);
-					PERFORM increase_nodes_right(parent.node_right - 1, node.node_left, 2);
+					PERFORM increase_nodes_left(tree, parent.node_right - 1, node.node_left, 2);
+					PERFORM increase_nodes_right(tree, parent.node_right - 1, node.node_left, 2);
 
 					UPDATE nodes 
 					SET node_left = parent.node_right,
 					node_right = parent.node_right + 1
-					WHERE name = node.name;
+					WHERE id = node.id;
 
 				/* * * * * * * * * * * * * * * * * * * *
 				* up moving along branch
@@ -199,27 +823,27 @@ func queriesForCreatingDb() []string {
 
 					-- to the right parent edge (nearest edge)
 					IF  parent.node_right - node.node_right < node.node_left - parent.node_left THEN
-						PERFORM increase_nodes_left(node.node_left, node.node_right, -1);
-						PERFORM increase_nodes_right(node.node_left, node.node_right, -1);
+						PERFORM increase_nodes_left(tree, node.node_left, node.node_right, -1);
+						PERFORM increase_nodes_right(tree, node.node_left, node.node_right, -1);
 
-						PERFORM increase_nodes_left(node.node_right, parent.node_right, -2);
-						PERFORM increase_nodes_right(node.node_right, parent.node_right, -2);
+						PERFORM increase_nodes_left(tree, node.node_right, parent.node_right, -2);
+						PERFORM increase_nodes_right(tree, node.node_right, parent.node_right, -2);
 
 						UPDATE nodes 
 						SET node_left = parent.node_right - 2,
 						node_right = parent.node_right - 1
-						WHERE name = node.name;
+						WHERE id = node.id;
 					ELSE -- to the left parent edge (nearest edge)
-						PERFORM increase_nodes_left(node.node_left, node.node_right, 1);
-						PERFORM increase_nodes_right(node.node_left, node.node_right, 1);
+						PERFORM increase_nodes_left(tree, node.node_left, node.node_right, 1);
+						PERFORM increase_nodes_right(tree, node.node_left, node.node_right, 1);
 
-						PERFORM increase_nodes_left(parent.node_left, node.node_left, 2);
-						PERFORM increase_nodes_right(parent.node_left, node.node_left, 2);
+						PERFORM increase_nodes_left(tree, parent.node_left, node.node_left, 2);
+						PERFORM increase_nodes_right(tree, parent.node_left, node.node_left, 2);
 
 						UPDATE nodes 
 						SET node_left = parent.node_left + 1,
 						node_right = parent.node_left + 2
-						WHERE name = node.name;
+						WHERE id = node.id;
 					END IF;
 
 				/* * * * * * * * * * * * * * * * * * * *
@@ -227,26 +851,251 @@ func queriesForCreatingDb() []string {
 				* * * * * * * * * * * * * * * * * * * */
 				ELSEIF parent.node_right < node.node_right AND parent.node_left > node.node_left THEN
 				
-					PERFORM increase_nodes_left(node.node_left, parent.node_left + 1, -1);
-					PERFORM increase_nodes_right(node.node_left, parent.node_left + 1, -1);
+					PERFORM increase_nodes_left(tree, node.node_left, parent.node_left + 1, -1);
+					PERFORM increase_nodes_right(tree, node.node_left, parent.node_left + 1, -1);
 
-					PERFORM increase_nodes_left(parent.node_left, node.node_right, 1);
-					PERFORM increase_nodes_right(parent.node_left, node.node_right, 1);
+					PERFORM increase_nodes_left(tree, parent.node_left, node.node_right, 1);
+					PERFORM increase_nodes_right(tree, parent.node_left, node.node_right, 1);
 
 					UPDATE nodes 
 					SET node_left = parent.node_left,
 					node_right = parent.node_left + 1
-					WHERE name = node.name;
+					WHERE id = node.id;
 
 				END IF;
 
+			END IF;
+
+			IF result = 0 THEN
+
+				-- the children take the place of the node
+				UPDATE nodes
+				SET parent_id = (SELECT p.parent_id FROM nodes AS p WHERE p.id = node.id)
+				WHERE parent_id = node.id;
+
+				UPDATE nodes
+				SET parent_id = parent_node_id
+				WHERE id = node.id;
+
+				-- the history records the parent changes, not the new place among the same siblings
+				PERFORM record_history(tree, ARRAY[parent_node_id]);
+
+			END IF;
+
+			RETURN result;
+		END;
+		$$  LANGUAGE plpgsql`,
+
+		`CREATE OR REPLACE FUNCTION move_subtree (tree INT, node_name varchar(100), parent_name varchar(100), 
+			pos_kind varchar(10), sibling_name varchar(100)) 
+		RETURNS INT AS $$
+		DECLARE
+			node_id INT := resolve_node(tree, node_name);
+			parent_node_id INT := resolve_node(tree, parent_name);
+			node RECORD;
+			parent RECORD;
+			boundary INT;
+			result INT := 0; -- see treestorage result codes
+		BEGIN
+			SELECT id, node_left, node_right, name
+			INTO node
+			FROM nodes
+			WHERE 
+				id = node_id;
+
+			SELECT node_left, node_right
+			INTO parent
+			FROM nodes
+			WHERE 
+				id = parent_node_id;
+
+			IF parent IS NOT NULL THEN
+				boundary := child_boundary(tree, parent.node_left, parent.node_right, pos_kind, sibling_name);
+			END IF;
+
+			IF node_id = 0 OR parent_node_id = 0 OR boundary = -1 THEN
+				result := 7; -- ambiguous name
+			ELSEIF node IS NULL THEN
+				result := 1; -- node not found
+			ELSEIF parent IS NULL THEN
+				result := 2; -- parent not found
+			ELSEIF parent.node_left >= node.node_left AND parent.node_right <= node.node_right THEN
+				result := 4; -- move into own subtree
+			ELSEIF boundary IS NULL THEN
+				result := 5; -- sibling not found
+			ELSEIF name_taken(tree, node.id, node.name, parent.node_left, parent.node_right) THEN
+				result := 3; -- node already exists
 			ELSE
-				result := 'move node fail: parent or node not found';
+				PERFORM move_range(tree, node.node_left, node.node_right, boundary);
+
+				UPDATE nodes
+				SET parent_id = parent_node_id
+				WHERE id = node.id;
+
+				-- the history records the parent changes, not the new place among the same siblings
+				PERFORM record_history(tree, ARRAY[parent_node_id]);
 			END IF;
 
 			RETURN result;
 		END;
 		$$  LANGUAGE plpgsql`,
+
+		`CREATE OR REPLACE FUNCTION reorder_children (tree INT, parent_name varchar(100), child_names varchar(100)[]) 
+		RETURNS INT AS $$
+		DECLARE
+			parent_node_id INT := resolve_node(tree, parent_name);
+			parent RECORD;
+			children_count INT;
+			matched_count INT;
+			joined_count INT;
+		BEGIN
+			SELECT node_left, node_right
+			INTO parent
+			FROM nodes
+			WHERE 
+				id = parent_node_id;
+
+			IF parent_node_id = 0 THEN
+				RETURN 7; -- ambiguous name
+			ELSEIF parent IS NULL THEN
+				RETURN 2; -- parent not found
+			END IF;
+
+			SELECT COUNT(*)
+			INTO children_count
+			FROM nodes AS c
+			WHERE c.tree_id = tree AND c.node_left > parent.node_left AND c.node_right < parent.node_right
+				AND NOT EXISTS (SELECT 1 FROM nodes AS a
+								WHERE a.tree_id = tree AND a.node_left > parent.node_left
+									AND a.node_left < c.node_left AND a.node_right > c.node_right);
+
+			-- the children are named by names or ids, a name shared by several children matches them all
+			SELECT COUNT(DISTINCT c.id), COUNT(*)
+			INTO matched_count, joined_count
+			FROM unnest(child_names) AS w(name)
+			JOIN nodes AS c ON c.tree_id = tree AND (c.name = w.name OR 'id:' || c.id = w.name)
+			WHERE c.node_left > parent.node_left AND c.node_right < parent.node_right
+				AND NOT EXISTS (SELECT 1 FROM nodes AS a
+								WHERE a.tree_id = tree AND a.node_left > parent.node_left
+									AND a.node_left < c.node_left AND a.node_right > c.node_right);
+
+			IF children_count <> matched_count OR children_count <> joined_count
+				OR children_count <> COALESCE(array_length(child_names, 1), 0) THEN
+				RETURN 6; -- children mismatch
+			END IF;
+
+			-- every child subtree is shifted to the sum of the widths of the subtrees before it
+			WITH wanted AS (
+				SELECT c.node_left, c.node_right, w.ord
+				FROM unnest(child_names) WITH ORDINALITY AS w(name, ord)
+				JOIN nodes AS c ON c.tree_id = tree AND (c.name = w.name OR 'id:' || c.id = w.name)
+				WHERE c.node_left > parent.node_left AND c.node_right < parent.node_right
+					AND NOT EXISTS (SELECT 1 FROM nodes AS a
+									WHERE a.tree_id = tree AND a.node_left > parent.node_left
+										AND a.node_left < c.node_left AND a.node_right > c.node_right)),
+			moves AS (
+				SELECT node_left, node_right,
+					parent.node_left + 1
+						+ SUM(node_right - node_left + 1) OVER (ORDER BY ord)
+						- (node_right - node_left + 1) - node_left AS shift
+				FROM wanted)
+			UPDATE nodes AS n
+			SET node_left = n.node_left + m.shift,
+				node_right = n.node_right + m.shift
+			FROM moves AS m
+			WHERE n.tree_id = tree AND n.node_left >= m.node_left AND n.node_right <= m.node_right AND m.shift <> 0;
+
+			PERFORM record_history(tree, ARRAY[parent_node_id]);
+
+			RETURN 0;
+		END;
+		$$  LANGUAGE plpgsql`,
+
+		`CREATE OR REPLACE FUNCTION rebuild_tree (tree INT) 
+		RETURNS INT AS $$
+		DECLARE
+			total INT;
+			rebuilt INT;
+		BEGIN
+			SELECT COUNT(*)
+			INTO total
+			FROM nodes
+			WHERE tree_id = tree;
+
+			-- the nodes are numbered in the preorder of the parent ids, the siblings keep their order:
+			-- left = 2 * preorder - depth, right = left + 2 * descendants + 1
+			WITH RECURSIVE ranked AS (
+				SELECT id, parent_id, ROW_NUMBER() OVER (PARTITION BY parent_id ORDER BY node_left, id) AS rank
+				FROM nodes
+				WHERE tree_id = tree),
+			walk AS (
+				SELECT id, ARRAY[rank] AS path, ARRAY[id] AS ancestors
+				FROM ranked
+				WHERE parent_id IS NULL
+				UNION ALL
+				SELECT r.id, w.path || r.rank, w.ancestors || r.id
+				FROM ranked AS r JOIN walk AS w ON r.parent_id = w.id),
+			ordered AS (
+				SELECT id, array_length(path, 1) - 1 AS depth, ROW_NUMBER() OVER (ORDER BY path) - 1 AS preorder
+				FROM walk),
+			sizes AS (
+				SELECT a.id, COUNT(*) - 1 AS descendants
+				FROM walk, unnest(walk.ancestors) AS a(id)
+				GROUP BY a.id)
+			UPDATE nodes AS n
+			SET node_left = 2 * o.preorder - o.depth,
+				node_right = 2 * o.preorder - o.depth + 2 * s.descendants + 1
+			FROM ordered AS o JOIN sizes AS s ON s.id = o.id
+			WHERE n.id = o.id;
+
+			GET DIAGNOSTICS rebuilt = ROW_COUNT;
+
+			-- the nodes unreachable from the roots are in parent cycles or refer to other trees
+			IF rebuilt <> total THEN
+				RETURN 8; -- broken parents
+			END IF;
+
+			-- the siblings may be ordered otherwise by the rebuilt numbers
+			PERFORM record_history(tree, NULL);
+
+			RETURN 0;
+		END;
+		$$  LANGUAGE plpgsql`,
+
+		`CREATE OR REPLACE FUNCTION rename_node (tree INT, node_name varchar(100), new_name varchar(100)) 
+		RETURNS INT AS $$
+		DECLARE
+			node_id INT := resolve_node(tree, node_name);
+			node RECORD;
+			parent RECORD;
+		BEGIN
+			SELECT node_left, node_right
+			INTO node
+			FROM nodes
+			WHERE 
+				id = node_id;
+
+			IF node_id = 0 THEN
+				RETURN 7; -- ambiguous name
+			ELSEIF node IS NULL THEN
+				RETURN 1; -- node not found
+			END IF;
+
+			SELECT *
+			INTO parent
+			FROM parent_range(tree, node.node_left, node.node_right);
+
+			IF name_taken(tree, node_id, new_name, parent.parent_left, parent.parent_right) THEN
+				RETURN 3; -- node already exists
+			END IF;
+
+			UPDATE nodes
+			SET name = new_name
+			WHERE id = node_id;
+
+			RETURN 0;
+		END;
+		$$  LANGUAGE plpgsql`,
 	}
 	return queries
 }
@@ -5,12 +5,21 @@ import (
 	"NestedSetsStorage/configs"
 	"NestedSetsStorage/dbmigrate"
 	"NestedSetsStorage/treestorage"
+	"context"
 	"flag"
+	"fmt"
 	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"github.com/BurntSushi/toml"
 )
 
+const _SHUTDOWN_TIMEOUT = 10 // seconds
+
 func main() {
 	var config = new(configs.Config)
 	_, err := toml.DecodeFile("configs/config.toml", config)
@@ -19,6 +28,8 @@ func main() {
 	}
 
 	isMigrate := flag.Bool("dbmigrate", false, "runs version migration for data base")
+	isVerify := flag.Bool("verify", false, "checks the integrity of every tree and exits")
+	isRebuild := flag.Bool("rebuild", false, "rebuilds the numbering of every tree from the parent ids, checks it and exits")
 	flag.Parse()
 	if *isMigrate == true {
 		log.Println("db version migration started")
@@ -29,13 +40,108 @@ func main() {
 		return
 	}
 
-	s := &treestorage.NestedSetsStorage{
-		DbConnectionString: config.DbConnectionSting,
-		DbDriver:           config.DbDriver}
+	s, err := treestorage.New(config.DbDriver, config.DbConnectionSting, treestorage.Options{
+		MaxOpenConns:    config.DbMaxOpenConns,
+		MaxIdleConns:    config.DbMaxIdleConns,
+		ConnMaxLifetime: time.Duration(config.DbConnMaxLifetime) * time.Second,
+		DefaultTimeout:  time.Duration(config.DbDefaultTimeout) * time.Millisecond,
+		Timeouts:        operationTimeouts(config.DbTimeouts),
+		NameUniqueness:  treestorage.NameUniqueness(config.NameUniqueness),
+	})
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer s.Close()
+
+	if *isRebuild == true {
+		err := rebuildTrees(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *isVerify == true || *isRebuild == true {
+		valid, err := verifyTrees(s)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if !valid {
+			s.Close()
+			os.Exit(1)
+		}
+		return
+	}
 
 	server := new(api.Server)
 	server.Config = config
 	server.Storage = s
 
-	log.Fatal(server.Start())
+	serverErr := make(chan error, 1)
+	go func() {
+		serverErr <- server.Start()
+	}()
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	select {
+	case err := <-serverErr:
+		if err != http.ErrServerClosed {
+			log.Println(err)
+		}
+	case <-stop:
+		log.Println("shutting down")
+		ctx, cancel := context.WithTimeout(context.Background(), _SHUTDOWN_TIMEOUT*time.Second)
+		defer cancel()
+		err := server.Shutdown(ctx)
+		if err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+func operationTimeouts(timeouts map[string]int) map[string]time.Duration {
+	result := make(map[string]time.Duration, len(timeouts))
+	for op, ms := range timeouts {
+		result[op] = time.Duration(ms) * time.Millisecond
+	}
+	return result
+}
+
+// rebuildTrees rebuilds the numbering of every tree from the parent ids
+func rebuildTrees(s *treestorage.NestedSetsStorage) error {
+	trees, err := s.ListTrees()
+	if err != nil {
+		return err
+	}
+
+	for _, tree := range trees {
+		err := s.WithTree(tree).Rebuild()
+		if err != nil {
+			return fmt.Errorf("tree %q: %w", tree, err)
+		}
+		log.Printf("tree %q rebuilt", tree)
+	}
+	return nil
+}
+
+// verifyTrees logs the integrity report of every tree and returns whether all the trees are valid
+func verifyTrees(s *treestorage.NestedSetsStorage) (bool, error) {
+	trees, err := s.ListTrees()
+	if err != nil {
+		return false, err
+	}
+
+	valid := true
+	for _, tree := range trees {
+		report, err := s.WithTree(tree).Verify()
+		if err != nil {
+			return false, err
+		}
+		log.Printf("tree %q: %d nodes, %d issues", report.Tree, report.NodeCount, len(report.Issues))
+		for _, issue := range report.Issues {
+			log.Printf("  %s at %d %v: %s", issue.Kind, issue.Boundary, issue.Nodes, issue.Message)
+		}
+		valid = valid && report.Valid
+	}
+	return valid, nil
 }
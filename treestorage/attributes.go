@@ -0,0 +1,148 @@
+package treestorage
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Attributes is the JSON object of the node attributes
+type Attributes map[string]interface{}
+
+// Scan decodes the nodes.attributes jsonb column, NULL is decoded to nil
+func (a *Attributes) Scan(src interface{}) error {
+	var data []byte
+	switch v := src.(type) {
+	case nil:
+		*a = nil
+		return nil
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("can not scan %T into the attributes", src)
+	}
+
+	attrs := Attributes{}
+	err := json.Unmarshal(data, &attrs)
+	if err != nil {
+		return err
+	}
+	*a = attrs
+	return nil
+}
+
+// Value encodes the attributes for the nodes.attributes jsonb column, nil is encoded to an empty object
+func (a Attributes) Value() (driver.Value, error) {
+	if a == nil {
+		return "{}", nil
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidArgument, err)
+	}
+	return string(data), nil
+}
+
+// GetAttributes returns the attributes of the node name
+func (s *NestedSetsStorage) GetAttributes(name string) (Attributes, error) {
+	return s.GetAttributesContext(context.Background(), name)
+}
+
+// GetAttributesContext returns the attributes of the node name
+func (s *NestedSetsStorage) GetAttributesContext(ctx context.Context, name string) (Attributes, error) {
+	if !validName(name) {
+		return nil, ErrInvalidName
+	}
+
+	ctx, cancel := s.withTimeout(ctx, OpGetAttrs)
+	defer cancel()
+
+	query := `SELECT n.attributes
+			  FROM trees AS t JOIN nodes AS n ON n.id = resolve_node(t.id, $2)
+			  WHERE t.name = $1;`
+	var attrs Attributes
+	err := s.db.QueryRowContext(ctx, query, s.tree, name).Scan(&attrs)
+	if err == sql.ErrNoRows {
+		return nil, s.checkNodeExists(ctx, name, ReadOptions{})
+	}
+	if err != nil {
+		return nil, storageError(err)
+	}
+
+	return attrs, nil
+}
+
+// SetAttributes replaces the attributes of the node name
+func (s *NestedSetsStorage) SetAttributes(name string, attrs Attributes) error {
+	return s.SetAttributesContext(context.Background(), name, attrs)
+}
+
+// SetAttributesContext replaces the attributes of the node name
+func (s *NestedSetsStorage) SetAttributesContext(ctx context.Context, name string, attrs Attributes) error {
+	return s.updateAttributes(ctx, name, `UPDATE nodes SET attributes = $2::jsonb WHERE id = $1;`, attrs,
+		Attributes{"mode": "set", "attributes": attrs})
+}
+
+// PatchAttributes merges attrs into the attributes of the node name,
+// the keys with null values are deleted
+func (s *NestedSetsStorage) PatchAttributes(name string, attrs Attributes) error {
+	return s.PatchAttributesContext(context.Background(), name, attrs)
+}
+
+// PatchAttributesContext merges attrs into the attributes of the node name,
+// the keys with null values are deleted
+func (s *NestedSetsStorage) PatchAttributesContext(ctx context.Context, name string, attrs Attributes) error {
+	patchQuery := `UPDATE nodes
+				   SET attributes = (attributes || $2::jsonb)
+						- ARRAY(SELECT key FROM jsonb_each($2::jsonb) WHERE value = 'null'::jsonb)
+				   WHERE id = $1;`
+	return s.updateAttributes(ctx, name, patchQuery, attrs, Attributes{"mode": "patch", "attributes": attrs})
+}
+
+// DeleteAttribute deletes the attribute key of the node name
+func (s *NestedSetsStorage) DeleteAttribute(name string, key string) error {
+	return s.DeleteAttributeContext(context.Background(), name, key)
+}
+
+// DeleteAttributeContext deletes the attribute key of the node name
+func (s *NestedSetsStorage) DeleteAttributeContext(ctx context.Context, name string, key string) error {
+	if key == "" {
+		return fmt.Errorf("%w: empty attribute key", ErrInvalidArgument)
+	}
+	return s.updateAttributes(ctx, name, `UPDATE nodes SET attributes = attributes - $2::text WHERE id = $1;`, key,
+		Attributes{"mode": "delete", "attribute": key})
+}
+
+// updateAttributes runs query updating the attributes of the node name,
+// the query gets the node id and arg, auditArgs are recorded to the audit log
+func (s *NestedSetsStorage) updateAttributes(ctx context.Context, name string, query string, arg interface{}, auditArgs Attributes) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+	if valuer, ok := arg.(driver.Valuer); ok {
+		var err error
+		arg, err = valuer.Value()
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := s.withTimeout(ctx, OpUpdateAttrs)
+	defer cancel()
+
+	entry := auditEntry{op: OpUpdateAttrs, node: name, args: auditArgs}
+	return s.inTx(ctx, func(tx *sql.Tx, tree int) error {
+		return audited(ctx, tx, tree, entry, func() (int, error) {
+			id, err := resolveNode(ctx, tx, tree, name)
+			if err != nil {
+				return 0, err
+			}
+			_, err = tx.ExecContext(ctx, query, id, arg)
+			return 0, storageError(err)
+		})
+	})
+}
@@ -0,0 +1,249 @@
+package treestorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const _DEFAULT_AUDIT_LIMIT = 100 // records
+const _MAX_AUDIT_LIMIT = 1000    // records
+
+type auditKey struct{}
+
+// AuditInfo is who makes the changes run with its context
+type AuditInfo struct {
+	Actor     string // the API key identity
+	RequestID string
+}
+
+// WithAuditInfo returns the context recording info to the audit log of the storage writes
+func WithAuditInfo(ctx context.Context, info AuditInfo) context.Context {
+	return context.WithValue(ctx, auditKey{}, info)
+}
+
+// AuditPosition is the place of a node in the tree
+type AuditPosition struct {
+	Parent string `json:"parent"` // empty for a root
+	Left   int    `json:"left"`
+	Right  int    `json:"right"`
+}
+
+// AuditRecord is a change of the tree
+type AuditRecord struct {
+	ID        int64
+	Operation string // one of the operation names
+	Node      string // the node name before the change, empty for the changes of the whole tree
+	NodeID    int
+	Arguments Attributes
+	Before    *AuditPosition // nil if the node did not exist
+	After     *AuditPosition // nil if the node was removed
+	Actor     string
+	RequestID string
+	Time      time.Time
+}
+
+// AuditFilter selects the audit records, the zero value selects the latest ones
+type AuditFilter struct {
+	Node      string    // the node name or reference by id
+	Operation string    // one of the operation names
+	From      time.Time // inclusive, zero means unbounded
+	To        time.Time // exclusive, zero means unbounded
+	Limit     int       // non-positive means the default limit
+}
+
+// GetAudit returns the audit records of the tree matching the filter, the latest first
+func (s *NestedSetsStorage) GetAudit(filter AuditFilter) ([]AuditRecord, error) {
+	return s.GetAuditContext(context.Background(), filter)
+}
+
+// GetAuditContext returns the audit records of the tree matching the filter, the latest first
+func (s *NestedSetsStorage) GetAuditContext(ctx context.Context, filter AuditFilter) ([]AuditRecord, error) {
+	if filter.Limit <= 0 {
+		filter.Limit = _DEFAULT_AUDIT_LIMIT
+	}
+	if filter.Limit > _MAX_AUDIT_LIMIT {
+		return []AuditRecord{}, fmt.Errorf("%w: the audit is limited to %d records", ErrInvalidArgument, _MAX_AUDIT_LIMIT)
+	}
+
+	ctx, cancel := s.withTimeout(ctx, OpGetAudit)
+	defer cancel()
+
+	query := `SELECT a.id, a.operation, a.node, COALESCE(a.node_id, 0), a.arguments,
+					a.position_before, a.position_after, a.actor, a.request_id, a.created_at
+			  FROM audit AS a
+			  WHERE a.tree = $1
+				AND ($2 = '' OR a.node = $2 OR 'id:' || a.node_id = $2)
+				AND ($3 = '' OR a.operation = $3)
+				AND ($4::timestamptz IS NULL OR a.created_at >= $4)
+				AND ($5::timestamptz IS NULL OR a.created_at < $5)
+			  ORDER BY a.id DESC
+			  LIMIT $6;`
+	rows, err := s.db.QueryContext(ctx, query, s.tree, filter.Node, filter.Operation,
+		nullTime(filter.From), nullTime(filter.To), filter.Limit)
+	if err != nil {
+		return []AuditRecord{}, storageError(err)
+	}
+	defer rows.Close()
+
+	result := []AuditRecord{}
+	for rows.Next() {
+		var record AuditRecord
+		var before, after []byte
+		err := rows.Scan(&record.ID, &record.Operation, &record.Node, &record.NodeID, &record.Arguments,
+			&before, &after, &record.Actor, &record.RequestID, &record.Time)
+		if err != nil {
+			return []AuditRecord{}, storageError(err)
+		}
+		record.Before, err = scanPosition(before)
+		if err != nil {
+			return []AuditRecord{}, err
+		}
+		record.After, err = scanPosition(after)
+		if err != nil {
+			return []AuditRecord{}, err
+		}
+		result = append(result, record)
+	}
+	err = rows.Err()
+	if err != nil {
+		return []AuditRecord{}, storageError(err)
+	}
+
+	if len(result) == 0 {
+		err = s.checkTreeExists(ctx)
+		if err != nil {
+			return []AuditRecord{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// auditEntry is the audit record of a change being made
+type auditEntry struct {
+	op   string
+	node string // the node reference, empty for the changes of the whole tree
+	args Attributes
+}
+
+// audited runs fn recording the change with the node positions before and after it,
+// fn returns the id of the node it adds or zero. The record is written in the transaction of the change
+func audited(ctx context.Context, tx *sql.Tx, tree int, entry auditEntry, fn func() (int, error)) error {
+	var id int
+	var name string
+	var before *AuditPosition
+	if entry.node != "" {
+		var err error
+		id, name, before, err = nodePosition(ctx, tx, tree, entry.node)
+		if err != nil {
+			return err
+		}
+	}
+
+	added, err := fn()
+	if err != nil {
+		return err
+	}
+	if added != 0 {
+		// the name of the added node may be taken by another node of the tree
+		id, name, before = added, "", nil
+	}
+
+	var after *AuditPosition
+	if entry.node != "" {
+		// the node is found by id after a rename and by name if it was not found before
+		ref := entry.node
+		if id != 0 {
+			ref = NodeRef(id)
+		}
+		var afterID int
+		var afterName string
+		afterID, afterName, after, err = nodePosition(ctx, tx, tree, ref)
+		if err != nil {
+			return err
+		}
+		if id == 0 {
+			id = afterID
+		}
+		if name == "" {
+			name = afterName
+		}
+	}
+	if name == "" {
+		name = entry.node
+	}
+
+	return recordAudit(ctx, tx, tree, entry, id, name, before, after)
+}
+
+// recordAudit writes the audit record of the change of the tree with id tree
+// made by the actor of the context, the tree must exist in the transaction
+func recordAudit(ctx context.Context, tx *sql.Tx, tree int, entry auditEntry,
+	id int, name string, before *AuditPosition, after *AuditPosition) error {
+	args, err := entry.args.Value()
+	if err != nil {
+		return err
+	}
+	info, _ := ctx.Value(auditKey{}).(AuditInfo)
+
+	query := `INSERT INTO audit
+			  (tree, operation, node, node_id, arguments, position_before, position_after, actor, request_id)
+			  SELECT t.name, $2, $3, NULLIF($4, 0), $5, $6, $7, $8, $9
+			  FROM trees AS t
+			  WHERE t.id = $1;`
+	_, err = tx.ExecContext(ctx, query, tree, entry.op, name, id, args,
+		positionValue(before), positionValue(after), info.Actor, info.RequestID)
+	return storageError(err)
+}
+
+// nodePosition returns the id, the name and the position of the node ref,
+// zero values if there is no such node or the name is ambiguous
+func nodePosition(ctx context.Context, tx *sql.Tx, tree int, ref string) (int, string, *AuditPosition, error) {
+	query := `SELECT n.id, n.name, COALESCE(p.name, ''), n.node_left, n.node_right
+			  FROM nodes AS n LEFT JOIN nodes AS p ON p.id = n.parent_id
+			  WHERE n.id = resolve_node($1, $2);`
+	var id int
+	var name string
+	var pos AuditPosition
+	err := tx.QueryRowContext(ctx, query, tree, ref).Scan(&id, &name, &pos.Parent, &pos.Left, &pos.Right)
+	if err == sql.ErrNoRows {
+		return 0, "", nil, nil
+	}
+	if err != nil {
+		return 0, "", nil, storageError(err)
+	}
+	return id, name, &pos, nil
+}
+
+// positionValue encodes the position for the audit jsonb columns, nil is NULL
+func positionValue(pos *AuditPosition) interface{} {
+	if pos == nil {
+		return nil
+	}
+	data, _ := json.Marshal(pos)
+	return string(data)
+}
+
+// scanPosition decodes the position of the audit jsonb columns, NULL is nil
+func scanPosition(data []byte) (*AuditPosition, error) {
+	if data == nil {
+		return nil, nil
+	}
+	var pos AuditPosition
+	err := json.Unmarshal(data, &pos)
+	if err != nil {
+		return nil, err
+	}
+	return &pos, nil
+}
+
+// nullTime returns nil for the zero time
+func nullTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+	return t
+}
@@ -0,0 +1,108 @@
+package treestorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+const _MAX_BATCH_SIZE = 1000 // operations
+
+// BatchOpKind is the kind of a batch operation
+type BatchOpKind string
+
+// The batch operation kinds
+const (
+	BatchAdd    BatchOpKind = "add"    // AddNode
+	BatchMove   BatchOpKind = "move"   // MoveNode or MoveSubtree
+	BatchRemove BatchOpKind = "remove" // RemoveNode
+	BatchRename BatchOpKind = "rename" // RenameNode
+	BatchRoot   BatchOpKind = "root"   // AddRoot
+)
+
+// BatchOp is a tree change of a batch, the fields follow the arguments of the matching method
+type BatchOp struct {
+	Op       BatchOpKind `json:"op"`
+	Name     string      `json:"name"`
+	Parent   string      `json:"parent,omitempty"`   // add and move
+	Position Position    `json:"position"`           // add and move, a missing position is the zero value
+	Subtree  bool        `json:"subtree,omitempty"`  // move with all the descendants
+	Mode     RemoveMode  `json:"mode,omitempty"`     // remove
+	Target   string      `json:"target,omitempty"`   // remove with RemoveReassign
+	NewName  string      `json:"new_name,omitempty"` // rename
+}
+
+// BatchResult is the result of a batch operation
+type BatchResult struct {
+	Op      BatchOpKind `json:"op"`
+	Name    string      `json:"name"`
+	Removed []string    `json:"removed,omitempty"`
+	Error   string      `json:"error,omitempty"`
+}
+
+// Batch runs the operations in order in a single transaction,
+// it returns the results up to the first failed operation and rolls back all the changes if there is one
+func (s *NestedSetsStorage) Batch(ops []BatchOp) ([]BatchResult, error) {
+	return s.BatchContext(context.Background(), ops)
+}
+
+// BatchContext runs the operations in order in a single transaction,
+// it returns the results up to the first failed operation and rolls back all the changes if there is one
+func (s *NestedSetsStorage) BatchContext(ctx context.Context, ops []BatchOp) ([]BatchResult, error) {
+	if len(ops) > _MAX_BATCH_SIZE {
+		return []BatchResult{}, fmt.Errorf("%w: the batch is limited to %d operations", ErrInvalidArgument, _MAX_BATCH_SIZE)
+	}
+
+	ctx, cancel := s.withTimeout(ctx, OpBatch)
+	defer cancel()
+
+	results := make([]BatchResult, 0, len(ops))
+	err := s.inTx(ctx, func(tx *sql.Tx, tree int) error {
+		results = results[:0]
+		for i, op := range ops {
+			result := BatchResult{Op: op.Op, Name: op.Name}
+			removed, err := runBatchOp(ctx, tx, tree, op)
+			if err != nil {
+				result.Error = err.Error()
+				results = append(results, result)
+				return fmt.Errorf("operation %d: %w", i, err)
+			}
+			if op.Op == BatchRemove {
+				result.Removed = removed
+			}
+			results = append(results, result)
+		}
+		return nil
+	})
+
+	return results, err
+}
+
+// runBatchOp checks the arguments of the operation and runs it in the transaction
+func runBatchOp(ctx context.Context, tx *sql.Tx, tree int, op BatchOp) ([]string, error) {
+	var fn nodeOp
+	var err error
+	switch op.Op {
+	case BatchAdd:
+		fn, err = addNodeOp(op.Name, op.Parent, op.Position)
+	case BatchMove:
+		if op.Subtree {
+			fn, err = moveSubtreeOp(op.Name, op.Parent, op.Position)
+		} else {
+			fn, err = moveNodeOp(op.Name, op.Parent, op.Position)
+		}
+	case BatchRemove:
+		fn, err = removeNodeOp(op.Name, op.Mode, op.Target)
+	case BatchRename:
+		fn, err = renameNodeOp(op.Name, op.NewName)
+	case BatchRoot:
+		fn, err = addRootOp(op.Name)
+	default:
+		err = fmt.Errorf("%w: unknown operation %q", ErrInvalidArgument, op.Op)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return fn.apply(ctx, tx, tree)
+}
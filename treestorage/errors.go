@@ -0,0 +1,103 @@
+package treestorage
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net"
+
+	"github.com/lib/pq"
+)
+
+// The storage errors, check them with errors.Is
+var (
+	ErrNodeNotFound        = errors.New("node not found")
+	ErrParentNotFound      = errors.New("parent not found")
+	ErrSiblingNotFound     = errors.New("sibling not found among the parent children")
+	ErrNodeExists          = errors.New("node already exists")
+	ErrTreeNotFound        = errors.New("tree not found")
+	ErrTreeExists          = errors.New("tree already exists")
+	ErrAmbiguousName       = errors.New("several nodes have the name, refer to the node by id")
+	ErrBrokenParents       = errors.New("the parent ids do not form a tree")
+	ErrInvalidName         = errors.New("invalid node name")
+	ErrMoveIntoSubtree     = errors.New("node can not be moved into its own subtree")
+	ErrChildrenMismatch    = errors.New("the names do not match the parent children")
+	ErrInvalidArgument     = errors.New("invalid argument")
+	ErrVersionMismatch     = errors.New("the tree changed since the expected version")
+	ErrDatabaseUnavailable = errors.New("database unavailable")
+)
+
+// result codes of the stored functions
+const (
+	resultOK               = 0
+	resultNodeNotFound     = 1
+	resultParentNotFound   = 2
+	resultNodeExists       = 3
+	resultMoveIntoSubtree  = 4
+	resultSiblingNotFound  = 5
+	resultChildrenMismatch = 6
+	resultAmbiguousName    = 7
+	resultBrokenParents    = 8
+)
+
+var resultErrors = map[int]error{
+	resultNodeNotFound:     ErrNodeNotFound,
+	resultParentNotFound:   ErrParentNotFound,
+	resultNodeExists:       ErrNodeExists,
+	resultMoveIntoSubtree:  ErrMoveIntoSubtree,
+	resultSiblingNotFound:  ErrSiblingNotFound,
+	resultChildrenMismatch: ErrChildrenMismatch,
+	resultAmbiguousName:    ErrAmbiguousName,
+	resultBrokenParents:    ErrBrokenParents,
+}
+
+// resultError converts a stored function result code to an error
+func resultError(code int) error {
+	if code == resultOK {
+		return nil
+	}
+	err, ok := resultErrors[code]
+	if !ok {
+		return fmt.Errorf("unknown stored function result code %d", code)
+	}
+	return err
+}
+
+// storageError converts driver errors to the storage errors
+func storageError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch {
+		case pqErr.Code.Name() == "unique_violation" && pqErr.Constraint == "trees_name_key":
+			return ErrTreeExists
+		case pqErr.Code.Name() == "unique_violation":
+			return ErrNodeExists
+		case pqErr.Code.Class() == "08",
+			pqErr.Code.Name() == "cannot_connect_now",
+			pqErr.Code.Name() == "too_many_connections",
+			pqErr.Code.Name() == "admin_shutdown":
+			return fmt.Errorf("%w: %v", ErrDatabaseUnavailable, err)
+		}
+		return err
+	}
+
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.As(err, &netErr) {
+		return fmt.Errorf("%w: %v", ErrDatabaseUnavailable, err)
+	}
+
+	return err
+}
+
+// retryable checks the transaction failed on a serialization failure or a deadlock and can be run again
+func retryable(err error) bool {
+	var pqErr *pq.Error
+	if !errors.As(err, &pqErr) {
+		return false
+	}
+	return pqErr.Code.Name() == "serialization_failure" || pqErr.Code.Name() == "deadlock_detected"
+}
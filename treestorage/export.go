@@ -0,0 +1,114 @@
+package treestorage
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ExportFormat is the format of the exported tree
+type ExportFormat string
+
+// The export formats
+const (
+	ExportDOT     ExportFormat = "dot"     // Graphviz digraph
+	ExportMermaid ExportFormat = "mermaid" // Mermaid flowchart
+	ExportCSV     ExportFormat = "csv"     // adjacency list: name, parent, depth, position
+)
+
+// WriteExport writes the nested sets nodes in any order in the format,
+// the depth and the parents are counted from the topmost nodes
+func WriteExport(w io.Writer, format ExportFormat, nodes []NestedSetsNode) error {
+	roots := BuildTree(nodes)
+	switch format {
+	case ExportDOT:
+		return writeDOT(w, roots)
+	case ExportMermaid:
+		return writeMermaid(w, roots)
+	case ExportCSV:
+		return writeCSV(w, roots)
+	default:
+		return fmt.Errorf("%w: unknown export format %q", ErrInvalidArgument, format)
+	}
+}
+
+// Export writes the node root with all its descendants in the format,
+// an empty root means the whole tree
+func (s *NestedSetsStorage) Export(w io.Writer, root string, format ExportFormat) error {
+	return s.ExportContext(context.Background(), w, root, format)
+}
+
+// ExportContext writes the node root with all its descendants in the format,
+// an empty root means the whole tree
+func (s *NestedSetsStorage) ExportContext(ctx context.Context, w io.Writer, root string, format ExportFormat) error {
+	if format != ExportDOT && format != ExportMermaid && format != ExportCSV {
+		return fmt.Errorf("%w: unknown export format %q", ErrInvalidArgument, format)
+	}
+
+	nodes, err := s.getSubtree(ctx, subtreeOp(root), root, ReadOptions{})
+	if err != nil {
+		return err
+	}
+	return WriteExport(w, format, nodes)
+}
+
+// walkTree calls fn for every node in the preorder with the parent, the depth and the position among the siblings
+func walkTree(nodes []TreeNode, parent *TreeNode, depth int, fn func(node, parent *TreeNode, depth, position int)) {
+	for i := range nodes {
+		fn(&nodes[i], parent, depth, i)
+		walkTree(nodes[i].Children, &nodes[i], depth+1, fn)
+	}
+}
+
+func writeDOT(w io.Writer, roots []TreeNode) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("digraph tree {\n")
+	walkTree(roots, nil, 0, func(node, parent *TreeNode, depth, position int) {
+		fmt.Fprintf(b, "\tn%d [label=%s];\n", node.ID, dotQuote(node.Name))
+		if parent != nil {
+			fmt.Fprintf(b, "\tn%d -> n%d;\n", parent.ID, node.ID)
+		}
+	})
+	b.WriteString("}\n")
+	return b.Flush()
+}
+
+// dotQuote returns the DOT double-quoted string
+func dotQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(s) + `"`
+}
+
+func writeMermaid(w io.Writer, roots []TreeNode) error {
+	b := bufio.NewWriter(w)
+	b.WriteString("flowchart TD\n")
+	walkTree(roots, nil, 0, func(node, parent *TreeNode, depth, position int) {
+		fmt.Fprintf(b, "\tn%d[%s]\n", node.ID, mermaidQuote(node.Name))
+		if parent != nil {
+			fmt.Fprintf(b, "\tn%d --> n%d\n", parent.ID, node.ID)
+		}
+	})
+	return b.Flush()
+}
+
+// mermaidQuote returns the Mermaid quoted label, the quotes are written as entity codes
+func mermaidQuote(s string) string {
+	return `"` + strings.NewReplacer(`#`, `#35;`, `"`, `#quot;`).Replace(s) + `"`
+}
+
+func writeCSV(w io.Writer, roots []TreeNode) error {
+	c := csv.NewWriter(w)
+	c.Write([]string{"name", "parent", "depth", "position"})
+	walkTree(roots, nil, 0, func(node, parent *TreeNode, depth, position int) {
+		parentName := ""
+		if parent != nil {
+			parentName = parent.Name
+		}
+		c.Write([]string{node.Name, parentName, strconv.Itoa(depth), strconv.Itoa(position)})
+	})
+	c.Flush()
+	return c.Error()
+}
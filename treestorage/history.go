@@ -0,0 +1,35 @@
+package treestorage
+
+import (
+	"fmt"
+)
+
+// nodesSource returns the relation of the nodes read by a query and the function resolving the node reference $2,
+// the current nodes or the node versions valid at opts.AsOf passed as the query argument number arg.
+// The current state queries are not changed
+func nodesSource(opts ReadOptions, arg int) (string, string) {
+	if opts.AsOf.IsZero() {
+		return "nodes", "resolve_node(t.id, $2)"
+	}
+
+	// the versions keep the places among the siblings, nodes_at numbers the tree $1 from them
+	return fmt.Sprintf("nodes_at($1, $%d)", arg), fmt.Sprintf("resolve_node_at(t.id, $2, $%d)", arg)
+}
+
+// nodesSnapshot is nodesSource for the queries reading the nodes more than once, the recursive ones above all,
+// the node versions are numbered once by the WITH item it returns first, empty for the current nodes
+func nodesSnapshot(opts ReadOptions, arg int) (string, string, string) {
+	nodes, resolve := nodesSource(opts, arg)
+	if opts.AsOf.IsZero() {
+		return "", nodes, resolve
+	}
+	return fmt.Sprintf("snapshot AS MATERIALIZED (SELECT * FROM %s),", nodes), "snapshot", resolve
+}
+
+// withAsOf appends the time of opts to the query arguments if the query reads the node history
+func withAsOf(opts ReadOptions, args ...interface{}) []interface{} {
+	if opts.AsOf.IsZero() {
+		return args
+	}
+	return append(args, opts.AsOf)
+}
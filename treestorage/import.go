@@ -0,0 +1,569 @@
+package treestorage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// ImportMode is the way the imported nodes are combined with the tree
+type ImportMode string
+
+// The import modes
+const (
+	ImportReplace ImportMode = "replace" // the tree nodes are deleted before the import
+	ImportMerge   ImportMode = "merge"   // the nodes matching the existing ones keep their places and get the imported attributes
+)
+
+// ImportNode is an imported node, an empty parent means a root.
+// The parent is the name of an imported node or of an existing one if no imported node has the name,
+// or the reference to an existing node by id. The siblings are placed in the order they are imported.
+// A merged node matches the existing node with its name in a tree with the global name uniqueness
+// and the existing node with its name and parent in the other trees
+type ImportNode struct {
+	Name       string     `json:"name"`
+	Parent     string     `json:"parent"`
+	Attributes Attributes `json:"attributes,omitempty"`
+
+	parent int // the index of the parent node plus one set by FlattenTree, the nested names may repeat
+}
+
+// ImportResult is the number of the nodes created and updated by an import
+type ImportResult struct {
+	Created int
+	Updated int
+}
+
+// FlattenTree converts the nested nodes to the import nodes, the ids are ignored.
+// The import nodes keep referring to their nested parents if the names repeat
+func FlattenTree(roots []TreeNode) []ImportNode {
+	var result []ImportNode
+	var flatten func(nodes []TreeNode, parent string, parentIndex int)
+	flatten = func(nodes []TreeNode, parent string, parentIndex int) {
+		for _, node := range nodes {
+			result = append(result, ImportNode{Name: node.Name, Parent: parent, Attributes: node.Attributes, parent: parentIndex})
+			flatten(node.Children, node.Name, len(result))
+		}
+	}
+	flatten(roots, "", 0)
+	return result
+}
+
+// ParseImportCSV reads the import nodes from CSV with a header,
+// the name and parent columns are required and the other non-empty columns become string attributes
+func ParseImportCSV(r io.Reader) ([]ImportNode, error) {
+	reader := csv.NewReader(r)
+	reader.TrimLeadingSpace = true
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid CSV header: %v", ErrInvalidArgument, err)
+	}
+	nameColumn, parentColumn := -1, -1
+	for i, column := range header {
+		switch strings.TrimSpace(column) {
+		case "name":
+			nameColumn = i
+		case "parent":
+			parentColumn = i
+		}
+	}
+	if nameColumn < 0 || parentColumn < 0 {
+		return nil, fmt.Errorf("%w: the CSV header has no name or parent column", ErrInvalidArgument)
+	}
+
+	var result []ImportNode
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid CSV: %v", ErrInvalidArgument, err)
+		}
+
+		node := ImportNode{Name: record[nameColumn], Parent: record[parentColumn]}
+		for i, value := range record {
+			if i == nameColumn || i == parentColumn || value == "" {
+				continue
+			}
+			if node.Attributes == nil {
+				node.Attributes = Attributes{}
+			}
+			node.Attributes[strings.TrimSpace(header[i])] = value
+		}
+		result = append(result, node)
+	}
+
+	return result, nil
+}
+
+// UnmarshalImport decodes the import nodes from the JSON list of the name and parent pairs
+// or from the nested JSON of TreeNode roots
+func UnmarshalImport(data []byte, nested bool) ([]ImportNode, error) {
+	if nested {
+		var roots []TreeNode
+		err := json.Unmarshal(data, &roots)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid nested JSON: %v", ErrInvalidArgument, err)
+		}
+		return FlattenTree(roots), nil
+	}
+
+	var nodes []ImportNode
+	err := json.Unmarshal(data, &nodes)
+	if err != nil {
+		return nil, fmt.Errorf("%w: invalid JSON: %v", ErrInvalidArgument, err)
+	}
+	return nodes, nil
+}
+
+// NumberImport checks the import nodes form a forest with the globally unique names and numbers it from 0
+func NumberImport(nodes []ImportNode) ([]NestedSetsNode, error) {
+	plan, err := planImport(nodes, importTarget{uniqueness: UniqueGlobal})
+	if err != nil {
+		return nil, err
+	}
+
+	var result []NestedSetsNode
+	for _, block := range plan.blocks {
+		offset := 2 * len(result)
+		for _, row := range block.rows {
+			result = append(result, NestedSetsNode{Name: row.name, Left: row.left + offset, Right: row.right + offset})
+		}
+	}
+	return result, nil
+}
+
+// Import writes the nodes to the tree in a single transaction
+func (s *NestedSetsStorage) Import(nodes []ImportNode, mode ImportMode) (ImportResult, error) {
+	return s.ImportContext(context.Background(), nodes, mode)
+}
+
+// ImportContext writes the nodes to the tree in a single transaction
+func (s *NestedSetsStorage) ImportContext(ctx context.Context, nodes []ImportNode, mode ImportMode) (ImportResult, error) {
+	if mode != ImportReplace && mode != ImportMerge {
+		return ImportResult{}, fmt.Errorf("%w: unknown import mode %q", ErrInvalidArgument, mode)
+	}
+
+	ctx, cancel := s.withTimeout(ctx, OpImport)
+	defer cancel()
+
+	var result ImportResult
+	err := s.inTx(ctx, func(tx *sql.Tx, tree int) error {
+		entry := auditEntry{op: OpImport, args: Attributes{"mode": string(mode), "nodes": len(nodes)}}
+		return audited(ctx, tx, tree, entry, func() (int, error) {
+			var err error
+			result, err = importNodes(ctx, tx, tree, nodes, mode)
+			entry.args["created"] = result.Created
+			entry.args["updated"] = result.Updated
+			return 0, err
+		})
+	})
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	return result, nil
+}
+
+// importNodes writes the nodes to the tree in the transaction
+func importNodes(ctx context.Context, tx *sql.Tx, tree int, nodes []ImportNode, mode ImportMode) (ImportResult, error) {
+	target, err := loadImportTarget(ctx, tx, tree, mode)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	plan, err := planImport(nodes, target)
+	if err != nil {
+		return ImportResult{}, err
+	}
+
+	var result ImportResult
+	for i, node := range nodes {
+		id := plan.matched[i]
+		if id == 0 {
+			continue
+		}
+		result.Updated++
+		if node.Attributes == nil {
+			continue
+		}
+		attrs, err := node.Attributes.Value()
+		if err != nil {
+			return ImportResult{}, err
+		}
+		_, err = tx.ExecContext(ctx, `UPDATE nodes SET attributes = attributes || $2::jsonb WHERE id = $1;`, id, attrs)
+		if err != nil {
+			return ImportResult{}, storageError(err)
+		}
+	}
+
+	for _, block := range plan.blocks {
+		err := insertBlock(ctx, tx, tree, block)
+		if err != nil {
+			return ImportResult{}, err
+		}
+		result.Created += len(block.rows)
+	}
+	return result, nil
+}
+
+// importTarget is the tree the nodes are imported to
+type importTarget struct {
+	uniqueness NameUniqueness
+	parents    map[int]int           // the parent ids of the existing nodes by id, 0 for the roots
+	named      map[string][]int      // the ids of the existing nodes by name
+	children   map[importChild][]int // the ids of the existing nodes by parent id and name
+}
+
+// importChild is the key of the existing nodes by parent id and name
+type importChild struct {
+	parent int
+	name   string
+}
+
+// importParent is the parent of an import node: another import node,
+// an existing node or none for the roots
+type importParent struct {
+	index int // the index of the import node plus one, 0 if the parent is not imported
+	id    int // the id of the existing node, 0 for the roots
+}
+
+// importRow is a new node numbered inside its block
+type importRow struct {
+	name       string
+	parent     int // the index of the parent row, -1 for the parent of the block
+	left       int
+	right      int
+	attributes Attributes
+}
+
+// importBlock is the new nodes placed together: the last children of an existing node
+// or the new roots if parent is 0
+type importBlock struct {
+	parent int
+	rows   []importRow
+}
+
+// importPlan is the changes of an import: the ids of the existing nodes matched by the import nodes,
+// 0 for the new ones, and the numbered blocks of the new nodes
+type importPlan struct {
+	matched []int
+	blocks  []importBlock
+}
+
+// loadImportTarget reads the name uniqueness of the tree and the existing nodes merged with the import,
+// the tree nodes are deleted before the replacing import
+func loadImportTarget(ctx context.Context, tx *sql.Tx, tree int, mode ImportMode) (importTarget, error) {
+	target := importTarget{
+		parents:  map[int]int{},
+		named:    map[string][]int{},
+		children: map[importChild][]int{}}
+	err := tx.QueryRowContext(ctx, `SELECT name_uniqueness FROM trees WHERE id = $1;`, tree).Scan(&target.uniqueness)
+	if err != nil {
+		return importTarget{}, storageError(err)
+	}
+
+	if mode == ImportReplace {
+		_, err := tx.ExecContext(ctx, `DELETE FROM nodes WHERE tree_id = $1;`, tree)
+		return target, storageError(err)
+	}
+
+	rows, err := tx.QueryContext(ctx, `SELECT id, name, COALESCE(parent_id, 0) FROM nodes WHERE tree_id = $1;`, tree)
+	if err != nil {
+		return importTarget{}, storageError(err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, parent int
+		var name string
+		err := rows.Scan(&id, &name, &parent)
+		if err != nil {
+			return importTarget{}, storageError(err)
+		}
+		target.parents[id] = parent
+		target.named[name] = append(target.named[name], id)
+		key := importChild{parent: parent, name: name}
+		target.children[key] = append(target.children[key], id)
+	}
+	return target, storageError(rows.Err())
+}
+
+// planImport checks the import nodes, matches them with the existing nodes of the target
+// and groups the new ones into the numbered blocks
+func planImport(nodes []ImportNode, target importTarget) (importPlan, error) {
+	imported := make(map[string][]int, len(nodes))
+	for i, node := range nodes {
+		if !validNewName(node.Name) || (node.Parent != "" && !validName(node.Parent)) {
+			return importPlan{}, fmt.Errorf("%w: %q", ErrInvalidName, node.Name)
+		}
+		imported[node.Name] = append(imported[node.Name], i)
+		if target.uniqueness == UniqueGlobal && len(imported[node.Name]) > 1 {
+			return importPlan{}, fmt.Errorf("%w: duplicate node %q", ErrInvalidArgument, node.Name)
+		}
+	}
+
+	parents := make([]importParent, len(nodes))
+	for i, node := range nodes {
+		var err error
+		parents[i], err = target.resolveParent(node, imported)
+		if err != nil {
+			return importPlan{}, err
+		}
+	}
+
+	matched, err := target.match(nodes, parents)
+	if err != nil {
+		return importPlan{}, err
+	}
+
+	// the parents matched by the existing nodes are the existing ones
+	for i, parent := range parents {
+		if parent.index != 0 && matched[parent.index-1] != 0 {
+			parents[i] = importParent{id: matched[parent.index-1]}
+		}
+	}
+
+	if target.uniqueness == UniquePerParent {
+		siblings := make(map[importParent]map[string]bool, len(nodes))
+		for i, node := range nodes {
+			if siblings[parents[i]] == nil {
+				siblings[parents[i]] = map[string]bool{}
+			}
+			if siblings[parents[i]][node.Name] {
+				return importPlan{}, fmt.Errorf("%w: duplicate node %q", ErrInvalidArgument, node.Name)
+			}
+			siblings[parents[i]][node.Name] = true
+		}
+	}
+
+	children := make(map[int][]int, len(nodes))
+	var blockRoots []int
+	news := 0
+	for i := range nodes {
+		if matched[i] != 0 {
+			continue
+		}
+		news++
+		if parents[i].index == 0 {
+			blockRoots = append(blockRoots, i)
+		} else {
+			children[parents[i].index-1] = append(children[parents[i].index-1], i)
+		}
+	}
+
+	var blocks []importBlock
+	for _, root := range blockRoots {
+		if len(blocks) == 0 || blocks[len(blocks)-1].parent != parents[root].id {
+			blocks = append(blocks, importBlock{parent: parents[root].id})
+		}
+		block := &blocks[len(blocks)-1]
+		block.rows, _ = numberSubtree(block.rows, nodes, root, -1, children, 2*len(block.rows))
+	}
+	numbered := 0
+	for _, block := range blocks {
+		numbered += len(block.rows)
+	}
+
+	// the nodes never reached from the block roots have their parents in a cycle
+	if numbered != news {
+		return importPlan{}, fmt.Errorf("%w: the parents of %d nodes form a cycle", ErrInvalidArgument, news-numbered)
+	}
+
+	return importPlan{matched: matched, blocks: blocks}, nil
+}
+
+// resolveParent finds the parent of the import node among the imported nodes and then among the existing ones
+func (target importTarget) resolveParent(node ImportNode, imported map[string][]int) (importParent, error) {
+	switch {
+	case node.parent != 0:
+		return importParent{index: node.parent}, nil
+	case node.Parent == "":
+		return importParent{}, nil
+	case idRef.MatchString(node.Parent):
+		id, _ := strconv.Atoi(strings.TrimPrefix(node.Parent, _ID_REF_PREFIX))
+		if _, ok := target.parents[id]; !ok {
+			return importParent{}, fmt.Errorf("%w: %q of the node %q", ErrParentNotFound, node.Parent, node.Name)
+		}
+		return importParent{id: id}, nil
+	}
+
+	indexes := imported[node.Parent]
+	ids := target.named[node.Parent]
+	switch {
+	case len(indexes) == 1:
+		return importParent{index: indexes[0] + 1}, nil
+	case len(indexes) > 1 || len(ids) > 1:
+		return importParent{}, fmt.Errorf("%w: %q of the node %q", ErrAmbiguousName, node.Parent, node.Name)
+	case len(ids) == 1:
+		return importParent{id: ids[0]}, nil
+	}
+	return importParent{}, fmt.Errorf("%w: %q of the node %q", ErrParentNotFound, node.Parent, node.Name)
+}
+
+// match returns the ids of the existing nodes matched by the import nodes, 0 for the new nodes.
+// A node matched by name with another existing parent is an error
+func (target importTarget) match(nodes []ImportNode, parents []importParent) ([]int, error) {
+	const (
+		unvisited = iota
+		visiting
+		done
+	)
+	matched := make([]int, len(nodes))
+	states := make([]int, len(nodes))
+
+	var matchNode func(i int) error
+	matchNode = func(i int) error {
+		if states[i] != unvisited {
+			// a node visited again before it is done has the parents in a cycle, it is new
+			return nil
+		}
+		states[i] = visiting
+		defer func() { states[i] = done }()
+
+		// the existing parent, new if the parent is a new import node
+		parent, newParent := parents[i].id, false
+		if index := parents[i].index; index != 0 {
+			err := matchNode(index - 1)
+			if err != nil {
+				return err
+			}
+			parent, newParent = matched[index-1], matched[index-1] == 0
+		}
+
+		name := nodes[i].Name
+		var ids []int
+		if target.uniqueness == UniqueGlobal {
+			ids = target.named[name]
+		} else if !newParent {
+			ids = target.children[importChild{parent: parent, name: name}]
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		if len(ids) > 1 {
+			return fmt.Errorf("%w: %q", ErrAmbiguousName, name)
+		}
+		if newParent || target.parents[ids[0]] != parent {
+			return fmt.Errorf("%w: %q has another parent in the tree", ErrNodeExists, name)
+		}
+		matched[i] = ids[0]
+		return nil
+	}
+
+	for i := range nodes {
+		err := matchNode(i)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return matched, nil
+}
+
+// numberSubtree appends the import node i with its descendants to rows numbering them from next,
+// parent is the row of the node parent. It returns the rows and the number following the node right
+func numberSubtree(rows []importRow, nodes []ImportNode, i int, parent int, children map[int][]int, next int) ([]importRow, int) {
+	index := len(rows)
+	rows = append(rows, importRow{name: nodes[i].Name, parent: parent, left: next, attributes: nodes[i].Attributes})
+	next++
+	for _, child := range children[i] {
+		rows, next = numberSubtree(rows, nodes, child, index, children, next)
+	}
+	rows[index].right = next
+	return rows, next + 1
+}
+
+// insertBlock makes room for the block and inserts its rows,
+// the block is placed after the last child of the existing parent or after the last root
+func insertBlock(ctx context.Context, tx *sql.Tx, tree int, block importBlock) error {
+	var boundary int
+	if block.parent == 0 {
+		err := tx.QueryRowContext(ctx, `SELECT COALESCE(MAX(node_right), -1) + 1 FROM nodes WHERE tree_id = $1;`, tree).
+			Scan(&boundary)
+		if err != nil {
+			return storageError(err)
+		}
+	} else {
+		err := tx.QueryRowContext(ctx, `SELECT node_right FROM nodes WHERE id = $1;`, block.parent).Scan(&boundary)
+		if err != nil {
+			return storageError(err)
+		}
+
+		width := 2 * len(block.rows)
+		shiftQuery := `UPDATE nodes
+						SET node_left = CASE WHEN node_left >= $2 THEN node_left + $3 ELSE node_left END,
+							node_right = node_right + $3
+						WHERE tree_id = $1 AND node_right >= $2;`
+		_, err = tx.ExecContext(ctx, shiftQuery, tree, boundary, width)
+		if err != nil {
+			return storageError(err)
+		}
+	}
+
+	names := make([]string, len(block.rows))
+	lefts := make([]int64, len(block.rows))
+	rights := make([]int64, len(block.rows))
+	attrs := make([]string, len(block.rows))
+	for i, row := range block.rows {
+		names[i] = row.name
+		lefts[i] = int64(boundary + row.left)
+		rights[i] = int64(boundary + row.right)
+		value, err := row.attributes.Value()
+		if err != nil {
+			return err
+		}
+		attrs[i] = value.(string)
+	}
+
+	// the inserted rows are told apart by their left numbers, the names may repeat
+	insertQuery := `INSERT INTO nodes (tree_id, name, node_left, node_right, attributes)
+					SELECT $1, v.name, v.node_left, v.node_right, v.attributes
+					FROM unnest($2::varchar[], $3::int[], $4::int[], $5::jsonb[]) AS v (name, node_left, node_right, attributes)
+					RETURNING id, node_left;`
+	rows, err := tx.QueryContext(ctx, insertQuery, tree, pq.Array(names), pq.Array(lefts), pq.Array(rights), pq.Array(attrs))
+	if err != nil {
+		return storageError(err)
+	}
+	ids := map[int64]int64{}
+	for rows.Next() {
+		var id, left int64
+		err := rows.Scan(&id, &left)
+		if err != nil {
+			rows.Close()
+			return storageError(err)
+		}
+		ids[left] = id
+	}
+	rows.Close()
+	err = rows.Err()
+	if err != nil {
+		return storageError(err)
+	}
+
+	children := make([]int64, 0, len(block.rows))
+	parents := make([]int64, 0, len(block.rows))
+	for i, row := range block.rows {
+		children = append(children, ids[lefts[i]])
+		if row.parent < 0 {
+			parents = append(parents, int64(block.parent))
+		} else {
+			parents = append(parents, ids[lefts[row.parent]])
+		}
+	}
+	// 0 is the parent of the roots
+	parentQuery := `UPDATE nodes AS n
+					SET parent_id = NULLIF(v.parent_id, 0)
+					FROM unnest($1::int[], $2::int[]) AS v (id, parent_id)
+					WHERE n.id = v.id;`
+	_, err = tx.ExecContext(ctx, parentQuery, pq.Array(children), pq.Array(parents))
+	return storageError(err)
+}
@@ -0,0 +1,135 @@
+package treestorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+const _MAX_RIGHT = int(^uint(0) >> 1) // the right boundary of the roots
+
+// TreeNode is a node with its children, the children are ordered the way they are stored
+type TreeNode struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Attributes Attributes `json:"attributes,omitempty"`
+	Children   []TreeNode `json:"children"`
+}
+
+// BuildTree assembles the nested sets nodes in any order into the nested roots,
+// a node missing its parent among nodes goes to the nearest ancestor present or becomes a root
+func BuildTree(nodes []NestedSetsNode) []TreeNode {
+	sorted := make([]NestedSetsNode, len(nodes))
+	copy(sorted, nodes)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Left < sorted[j].Left })
+
+	roots, _ := buildLevel(sorted, 0, _MAX_RIGHT)
+	return roots
+}
+
+// buildLevel assembles the nodes ordered by left starting from i while they are inside the right boundary,
+// it returns the assembled nodes and the index of the first node outside the boundary
+func buildLevel(nodes []NestedSetsNode, i int, right int) ([]TreeNode, int) {
+	level := []TreeNode{}
+	for i < len(nodes) && nodes[i].Right < right {
+		node := nodes[i]
+		var children []TreeNode
+		children, i = buildLevel(nodes, i+1, node.Right)
+		level = append(level, TreeNode{
+			ID:         node.ID,
+			Name:       node.Name,
+			Attributes: node.Attributes,
+			Children:   children})
+	}
+	return level, i
+}
+
+// GetSubtree returns the node root with all its descendants ordered by left,
+// an empty root means the whole tree
+func (s *NestedSetsStorage) GetSubtree(root string, opts ReadOptions) ([]NestedSetsNode, error) {
+	return s.GetSubtreeContext(context.Background(), root, opts)
+}
+
+// GetSubtreeContext returns the node root with all its descendants ordered by left,
+// an empty root means the whole tree
+func (s *NestedSetsStorage) GetSubtreeContext(ctx context.Context, root string, opts ReadOptions) ([]NestedSetsNode, error) {
+	return s.getSubtree(ctx, subtreeOp(root), root, opts)
+}
+
+// subtreeOp returns the operation reading the node root with its descendants,
+// the whole tree is read with its own timeout
+func subtreeOp(root string) string {
+	if root == "" {
+		return OpGetWholeTree
+	}
+	return OpGetSubtree
+}
+
+// getSubtree reads the node root with all its descendants with the timeout of the operation op
+func (s *NestedSetsStorage) getSubtree(ctx context.Context, op string, root string, opts ReadOptions) ([]NestedSetsNode, error) {
+	if root != "" && !validName(root) {
+		return []NestedSetsNode{}, ErrInvalidName
+	}
+
+	ctx, cancel := s.withTimeout(ctx, op)
+	defer cancel()
+
+	nodes, resolve := nodesSource(opts, 4)
+	query := fmt.Sprintf(
+		`WITH root AS (SELECT t.id AS tree_id,
+						COALESCE(r.node_left, -1) AS node_left, COALESCE(r.node_right, 2147483647) AS node_right
+						FROM trees AS t LEFT JOIN %[1]s AS r ON $2 <> '' AND r.id = %[2]s
+						WHERE t.name = $1 AND ($2 = '' OR r.id IS NOT NULL))
+		SELECT n.id, n.name, n.node_left, n.node_right, CASE WHEN $3 THEN n.attributes END
+		FROM %[1]s AS n, root
+		WHERE n.tree_id = root.tree_id AND n.node_left >= root.node_left AND n.node_right <= root.node_right
+		ORDER BY n.node_left;`, nodes, resolve)
+	rows, err := s.db.QueryContext(ctx, query, withAsOf(opts, s.tree, root, opts.WithAttributes)...)
+	if err != nil {
+		return []NestedSetsNode{}, storageError(err)
+	}
+	defer rows.Close()
+
+	var result []NestedSetsNode
+	for rows.Next() {
+		var node NestedSetsNode
+		err := rows.Scan(&node.ID, &node.Name, &node.Left, &node.Right, &node.Attributes)
+		if err != nil {
+			return []NestedSetsNode{}, storageError(err)
+		}
+		result = append(result, node)
+	}
+	err = rows.Err()
+	if err != nil {
+		return []NestedSetsNode{}, storageError(err)
+	}
+
+	if len(result) == 0 {
+		if root == "" {
+			err = s.checkTreeExists(ctx)
+		} else {
+			err = s.checkNodeExists(ctx, root, opts)
+		}
+		if err != nil {
+			return []NestedSetsNode{}, err
+		}
+	}
+
+	return result, nil
+}
+
+// GetTree returns the node root with all its descendants nested into their parents,
+// an empty root means all the roots of the tree
+func (s *NestedSetsStorage) GetTree(root string, opts ReadOptions) ([]TreeNode, error) {
+	return s.GetTreeContext(context.Background(), root, opts)
+}
+
+// GetTreeContext returns the node root with all its descendants nested into their parents,
+// an empty root means all the roots of the tree
+func (s *NestedSetsStorage) GetTreeContext(ctx context.Context, root string, opts ReadOptions) ([]TreeNode, error) {
+	nodes, err := s.GetSubtreeContext(ctx, root, opts)
+	if err != nil {
+		return []TreeNode{}, err
+	}
+	return BuildTree(nodes), nil
+}
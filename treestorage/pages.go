@@ -0,0 +1,155 @@
+package treestorage
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+)
+
+const _DEFAULT_PAGE_SIZE = 1000 // nodes
+const _MAX_PAGE_SIZE = 10000    // nodes
+
+// NodesPage is a page of the tree nodes ordered by left
+type NodesPage struct {
+	Nodes []NestedSetsNode
+
+	// NextCursor is the cursor of the next page, empty on the last page
+	NextCursor string
+}
+
+// GetNodesPage returns up to limit nodes of the tree following the cursor ordered by left,
+// an empty cursor means the first page and a non-positive limit means the default page size.
+// The pages are consistent if the tree is not changed while they are read
+func (s *NestedSetsStorage) GetNodesPage(cursor string, limit int, opts ReadOptions) (NodesPage, error) {
+	return s.GetNodesPageContext(context.Background(), cursor, limit, opts)
+}
+
+// GetNodesPageContext returns up to limit nodes of the tree following the cursor ordered by left,
+// an empty cursor means the first page and a non-positive limit means the default page size.
+// The pages are consistent if the tree is not changed while they are read
+func (s *NestedSetsStorage) GetNodesPageContext(ctx context.Context, cursor string, limit int, opts ReadOptions) (NodesPage, error) {
+	after, err := decodeCursor(cursor)
+	if err != nil {
+		return NodesPage{}, err
+	}
+	if limit <= 0 {
+		limit = _DEFAULT_PAGE_SIZE
+	}
+	if limit > _MAX_PAGE_SIZE {
+		return NodesPage{}, fmt.Errorf("%w: the page size is limited to %d nodes", ErrInvalidArgument, _MAX_PAGE_SIZE)
+	}
+
+	ctx, cancel := s.withTimeout(ctx, OpGetWholeTree)
+	defer cancel()
+
+	// one extra node tells whether there is a next page
+	nodes, _ := nodesSource(opts, 5)
+	query := fmt.Sprintf(
+		`SELECT n.id, n.name, n.node_left, n.node_right, CASE WHEN $2 THEN n.attributes END
+		FROM %s AS n JOIN trees AS t ON t.id = n.tree_id
+		WHERE t.name = $1 AND n.node_left > $3
+		ORDER BY n.node_left
+		LIMIT $4;`, nodes)
+	rows, err := s.db.QueryContext(ctx, query, withAsOf(opts, s.tree, opts.WithAttributes, after, limit+1)...)
+	if err != nil {
+		return NodesPage{}, storageError(err)
+	}
+	defer rows.Close()
+
+	page := NodesPage{Nodes: []NestedSetsNode{}}
+	for rows.Next() {
+		var node NestedSetsNode
+		err := rows.Scan(&node.ID, &node.Name, &node.Left, &node.Right, &node.Attributes)
+		if err != nil {
+			return NodesPage{}, storageError(err)
+		}
+		page.Nodes = append(page.Nodes, node)
+	}
+	err = rows.Err()
+	if err != nil {
+		return NodesPage{}, storageError(err)
+	}
+
+	if len(page.Nodes) > limit {
+		page.Nodes = page.Nodes[:limit]
+		page.NextCursor = encodeCursor(page.Nodes[limit-1].Left)
+	}
+	if len(page.Nodes) == 0 && cursor == "" {
+		err = s.checkTreeExists(ctx)
+		if err != nil {
+			return NodesPage{}, err
+		}
+	}
+
+	return page, nil
+}
+
+// WalkWholeTree calls fn for every node of the tree ordered by left as the nodes are read,
+// the walk stops at the first fn error and returns it
+func (s *NestedSetsStorage) WalkWholeTree(opts ReadOptions, fn func(node NestedSetsNode) error) error {
+	return s.WalkWholeTreeContext(context.Background(), opts, fn)
+}
+
+// WalkWholeTreeContext calls fn for every node of the tree ordered by left as the nodes are read,
+// the walk stops at the first fn error and returns it
+func (s *NestedSetsStorage) WalkWholeTreeContext(ctx context.Context, opts ReadOptions, fn func(node NestedSetsNode) error) error {
+	ctx, cancel := s.withTimeout(ctx, OpGetWholeTree)
+	defer cancel()
+
+	nodes, _ := nodesSource(opts, 3)
+	query := fmt.Sprintf(
+		`SELECT n.id, n.name, n.node_left, n.node_right, CASE WHEN $2 THEN n.attributes END
+		FROM %s AS n JOIN trees AS t ON t.id = n.tree_id
+		WHERE t.name = $1
+		ORDER BY n.node_left;`, nodes)
+	rows, err := s.db.QueryContext(ctx, query, withAsOf(opts, s.tree, opts.WithAttributes)...)
+	if err != nil {
+		return storageError(err)
+	}
+	defer rows.Close()
+
+	walked := false
+	for rows.Next() {
+		var node NestedSetsNode
+		err := rows.Scan(&node.ID, &node.Name, &node.Left, &node.Right, &node.Attributes)
+		if err != nil {
+			return storageError(err)
+		}
+		err = fn(node)
+		if err != nil {
+			return err
+		}
+		walked = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return storageError(err)
+	}
+
+	if !walked {
+		return s.checkTreeExists(ctx)
+	}
+	return nil
+}
+
+// encodeCursor returns the cursor of the nodes following the left boundary
+func encodeCursor(left int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.Itoa(left)))
+}
+
+// decodeCursor returns the left boundary of the cursor, an empty cursor is before the first node
+func decodeCursor(cursor string) (int, error) {
+	if cursor == "" {
+		return -1, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, fmt.Errorf("%w: invalid cursor", ErrInvalidArgument)
+	}
+	left, err := strconv.Atoi(string(data))
+	if err != nil || left < 0 {
+		return 0, fmt.Errorf("%w: invalid cursor", ErrInvalidArgument)
+	}
+	return left, nil
+}
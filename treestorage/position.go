@@ -0,0 +1,96 @@
+package treestorage
+
+import (
+	"fmt"
+	"strings"
+)
+
+// PositionKind is the place of a node among the children of its parent
+type PositionKind string
+
+// The position kinds
+const (
+	PositionFirst  PositionKind = "first"
+	PositionLast   PositionKind = "last"
+	PositionBefore PositionKind = "before"
+	PositionAfter  PositionKind = "after"
+)
+
+// Position is the place of a node among the children of its parent,
+// the zero value is the last child position
+type Position struct {
+	Kind    PositionKind
+	Sibling string // the sibling name for PositionBefore and PositionAfter
+}
+
+// ParsePosition parses "first", "last", "before:<sibling>" or "after:<sibling>",
+// an empty string is the last child position
+func ParsePosition(s string) (Position, error) {
+	switch s {
+	case "", string(PositionLast):
+		return Position{Kind: PositionLast}, nil
+	case string(PositionFirst):
+		return Position{Kind: PositionFirst}, nil
+	}
+
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) == 2 && parts[1] != "" {
+		switch PositionKind(parts[0]) {
+		case PositionBefore, PositionAfter:
+			return Position{Kind: PositionKind(parts[0]), Sibling: parts[1]}, nil
+		}
+	}
+
+	return Position{}, fmt.Errorf("%w: unknown position %q", ErrInvalidArgument, s)
+}
+
+// String formats the position the way ParsePosition accepts it
+func (p Position) String() string {
+	switch p.Kind {
+	case PositionBefore, PositionAfter:
+		return string(p.Kind) + ":" + p.Sibling
+	case "":
+		return string(PositionLast)
+	}
+	return string(p.Kind)
+}
+
+// MarshalText formats the position the way ParsePosition accepts it, the zero value is empty
+func (p Position) MarshalText() ([]byte, error) {
+	if p.Kind == "" {
+		return []byte{}, nil
+	}
+	return []byte(p.String()), nil
+}
+
+// UnmarshalText parses the position with ParsePosition
+func (p *Position) UnmarshalText(text []byte) error {
+	pos, err := ParsePosition(string(text))
+	if err != nil {
+		return err
+	}
+	*p = pos
+	return nil
+}
+
+// validate checks the position kind and the sibling name
+func (p Position) validate() error {
+	switch p.Kind {
+	case "", PositionFirst, PositionLast:
+		return nil
+	case PositionBefore, PositionAfter:
+		if !validName(p.Sibling) {
+			return ErrInvalidName
+		}
+		return nil
+	}
+	return fmt.Errorf("%w: unknown position %q", ErrInvalidArgument, p.Kind)
+}
+
+// kind returns the position kind passed to the stored functions
+func (p Position) kind() string {
+	if p.Kind == "" {
+		return string(PositionLast)
+	}
+	return string(p.Kind)
+}
@@ -0,0 +1,116 @@
+package treestorage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+)
+
+// CreateTree creates a new empty tree with name name and the node names unique in the uniqueness scope,
+// an empty uniqueness means the storage default one
+func (s *NestedSetsStorage) CreateTree(name string, uniqueness NameUniqueness) error {
+	return s.CreateTreeContext(context.Background(), name, uniqueness)
+}
+
+// CreateTreeContext creates a new empty tree with name name and the node names unique in the uniqueness scope,
+// an empty uniqueness means the storage default one
+func (s *NestedSetsStorage) CreateTreeContext(ctx context.Context, name string, uniqueness NameUniqueness) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+	if uniqueness == "" {
+		uniqueness = s.nameUniqueness
+	}
+	if !uniqueness.valid() {
+		return fmt.Errorf("%w: unknown name uniqueness %q", ErrInvalidArgument, uniqueness)
+	}
+
+	ctx, cancel := s.withTimeout(ctx, OpCreateTree)
+	defer cancel()
+
+	entry := auditEntry{op: OpCreateTree, args: Attributes{"uniqueness": string(uniqueness)}}
+	return s.treeTx(ctx, func(tx *sql.Tx) error {
+		var tree int
+		err := tx.QueryRowContext(ctx, `INSERT INTO trees (name, name_uniqueness) VALUES ($1, $2) RETURNING id;`,
+			name, string(uniqueness)).Scan(&tree)
+		if err != nil {
+			return storageError(err)
+		}
+		return recordAudit(ctx, tx, tree, entry, 0, "", nil, nil)
+	})
+}
+
+// ListTrees returns the tree names ordered by name
+func (s *NestedSetsStorage) ListTrees() ([]string, error) {
+	return s.ListTreesContext(context.Background())
+}
+
+// ListTreesContext returns the tree names ordered by name
+func (s *NestedSetsStorage) ListTreesContext(ctx context.Context) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx, OpListTrees)
+	defer cancel()
+
+	return queryNames(ctx, s.db, `SELECT name FROM trees ORDER BY name;`)
+}
+
+// DeleteTree deletes the tree with name name together with all its nodes but not its audit records,
+// the default tree can not be deleted
+func (s *NestedSetsStorage) DeleteTree(name string) error {
+	return s.DeleteTreeContext(context.Background(), name)
+}
+
+// DeleteTreeContext deletes the tree with name name together with all its nodes but not its audit records,
+// the default tree can not be deleted
+func (s *NestedSetsStorage) DeleteTreeContext(ctx context.Context, name string) error {
+	if !validName(name) {
+		return ErrInvalidName
+	}
+	if name == DefaultTree {
+		return fmt.Errorf("%w: the %q tree can not be deleted", ErrInvalidArgument, DefaultTree)
+	}
+
+	ctx, cancel := s.withTimeout(ctx, OpDeleteTree)
+	defer cancel()
+
+	// the record is written while the tree exists and outlives it
+	return s.treeTx(ctx, func(tx *sql.Tx) error {
+		var tree int
+		err := tx.QueryRowContext(ctx, `SELECT id FROM trees WHERE name = $1 FOR UPDATE;`, name).Scan(&tree)
+		if err == sql.ErrNoRows {
+			return ErrTreeNotFound
+		}
+		if err != nil {
+			return storageError(err)
+		}
+
+		// the writers waiting for the tree lock find the tree deleted once they get it
+		_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2);`, _TREE_LOCK_CLASS, tree)
+		if err != nil {
+			return storageError(err)
+		}
+
+		err = recordAudit(ctx, tx, tree, auditEntry{op: OpDeleteTree}, 0, "", nil, nil)
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.ExecContext(ctx, `DELETE FROM trees WHERE id = $1;`, tree)
+		return storageError(err)
+	})
+}
+
+// treeTx runs fn creating or deleting a tree in a transaction rolled back if fn fails
+func (s *NestedSetsStorage) treeTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storageError(err)
+	}
+
+	err = fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return storageError(tx.Commit())
+}
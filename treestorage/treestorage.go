@@ -1,341 +1,1029 @@
 package treestorage
 
 import (
+	"context"
 	"database/sql"
-	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/lib/pq"
+)
+
+const _MAX_NAME_LENGTH = 100 // nodes.name is varchar(100)
+
+const _ID_REF_PREFIX = "id:"
+
+const _TREE_LOCK_CLASS = 0x7472 // the first key of the tree advisory locks, the second one is the tree id
+
+const _MAX_TX_ATTEMPTS = 5                      // the attempts of a transaction failing on a serialization failure or a deadlock
+const _TX_RETRY_BACKOFF = 10 * time.Millisecond // the delay before the first retry, doubled for every next one
+
+// idRef matches the node references by id, see NodeRef
+var idRef = regexp.MustCompile(`^id:[0-9]{1,9}$`)
+
+// DefaultPathSeparator is the separator of the rendered node paths
+const DefaultPathSeparator = " / "
+
+// DefaultTree is the tree of a storage without a tree name, it is created by dbmigrate
+const DefaultTree = "default"
+
+// Operation names, they are the keys of Options.Timeouts
+const (
+	OpGetParents   = "get_parents"
+	OpGetChildren  = "get_children"
+	OpGetPath      = "get_path"
+	OpGetWholeTree = "get_whole_tree"
+	OpGetSubtree   = "get_subtree"
+	OpAddNode      = "add_node"
+	OpMoveNode     = "move_node"
+	OpMoveSubtree  = "move_subtree"
+	OpRemoveNode   = "remove_node"
+	OpRenameNode   = "rename_node"
+	OpReorder      = "reorder_children"
+	OpSortChildren = "sort_children"
+	OpAddRoot      = "add_root"
+	OpCreateTree   = "create_tree"
+	OpListTrees    = "list_trees"
+	OpDeleteTree   = "delete_tree"
+	OpGetAttrs     = "get_attributes"
+	OpUpdateAttrs  = "update_attributes"
+	OpRebuild      = "rebuild"
+	OpImport       = "import"
+	OpBatch        = "batch"
+	OpGetVersion   = "get_version"
+	OpGetAudit     = "get_audit"
 )
 
 // NestedSetsNode is a tree node
 type NestedSetsNode struct {
-	Name  string
-	Left  int
-	Right int
+	ID         int
+	Name       string
+	Left       int
+	Right      int
+	Attributes Attributes `json:",omitempty"` // filled if ReadOptions.WithAttributes is set
+}
+
+// NodeRef returns the reference to the node id, it is accepted everywhere a node name is
+func NodeRef(id int) string {
+	return _ID_REF_PREFIX + strconv.Itoa(id)
+}
+
+// NameUniqueness is the scope the node names of a tree are unique in
+type NameUniqueness string
+
+// The name uniqueness scopes
+const (
+	UniqueGlobal    NameUniqueness = "global"     // the names are unique in the whole tree
+	UniquePerParent NameUniqueness = "per_parent" // the names are unique among the siblings
+	UniqueNone      NameUniqueness = "none"       // the names are not checked, the nodes are referred by ids
+)
+
+// NestedSetsChild is a descendant node with the depth relative to the requested node,
+// direct children have depth 1
+type NestedSetsChild struct {
+	ID         int
+	Name       string
+	Depth      int
+	Attributes Attributes `json:",omitempty"` // filled if ReadOptions.WithAttributes is set
+}
+
+// PathNode is an ancestor of a node, the names are ambiguous in the trees without the global name uniqueness
+type PathNode struct {
+	ID   int
+	Name string
 }
 
-// NestedSetsStorage is an interface for data base table
+// ReadOptions is the optional node data of the read operations
+type ReadOptions struct {
+	WithAttributes bool
+
+	// AsOf is the past time the tree is read at, zero means the current state
+	AsOf time.Time
+}
+
+// RemoveMode is the way the descendants of a removed node are handled
+type RemoveMode string
+
+// The remove modes
+const (
+	RemovePromote  RemoveMode = "promote"  // children take the place of the removed node
+	RemoveCascade  RemoveMode = "cascade"  // descendants are removed with the node
+	RemoveReassign RemoveMode = "reassign" // children become the last children of the target node
+)
+
+// SortOrder is the order of SortChildren
+type SortOrder struct {
+	Key        string // "name" or empty sorts by the node names, any other key is the attribute sorted by
+	Descending bool
+}
+
+// TreeStore is a nested sets tree storage, the node operations work with a single tree
+type TreeStore interface {
+	// Tree returns the store working with the tree name, an empty name means DefaultTree
+	Tree(name string) TreeStore
+	CreateTreeContext(ctx context.Context, name string, uniqueness NameUniqueness) error
+	ListTreesContext(ctx context.Context) ([]string, error)
+	DeleteTreeContext(ctx context.Context, name string) error
+
+	GetParentsContext(ctx context.Context, name string, opts ReadOptions) ([]PathNode, error)
+	GetChildrenContext(ctx context.Context, name string, maxDepth int, opts ReadOptions) ([]NestedSetsChild, error)
+	GetPathContext(ctx context.Context, name string, includeSelf bool) ([]PathNode, error)
+	GetWholeTreeContext(ctx context.Context, opts ReadOptions) ([]NestedSetsNode, error)
+	GetNodesPageContext(ctx context.Context, cursor string, limit int, opts ReadOptions) (NodesPage, error)
+	WalkWholeTreeContext(ctx context.Context, opts ReadOptions, fn func(node NestedSetsNode) error) error
+	GetSubtreeContext(ctx context.Context, root string, opts ReadOptions) ([]NestedSetsNode, error)
+	GetTreeContext(ctx context.Context, root string, opts ReadOptions) ([]TreeNode, error)
+	AddNodeContext(ctx context.Context, name string, parent string, pos Position) error
+	MoveNodeContext(ctx context.Context, name string, newParent string, pos Position) error
+	MoveSubtreeContext(ctx context.Context, name string, newParent string, pos Position) error
+	RemoveNodeContext(ctx context.Context, name string, mode RemoveMode, target string) ([]string, error)
+	RenameNodeContext(ctx context.Context, name string, newName string) error
+	ReorderChildrenContext(ctx context.Context, parent string, names []string) error
+	SortChildrenContext(ctx context.Context, parent string, order SortOrder) error
+	AddRootContext(ctx context.Context, name string) error
+	GetAttributesContext(ctx context.Context, name string) (Attributes, error)
+	SetAttributesContext(ctx context.Context, name string, attrs Attributes) error
+	PatchAttributesContext(ctx context.Context, name string, attrs Attributes) error
+	DeleteAttributeContext(ctx context.Context, name string, key string) error
+	VerifyContext(ctx context.Context) (VerifyReport, error)
+	RebuildContext(ctx context.Context) error
+	ImportContext(ctx context.Context, nodes []ImportNode, mode ImportMode) (ImportResult, error)
+	ExportContext(ctx context.Context, w io.Writer, root string, format ExportFormat) error
+	BatchContext(ctx context.Context, ops []BatchOp) ([]BatchResult, error)
+	TreeVersionContext(ctx context.Context) (int64, error)
+	GetAuditContext(ctx context.Context, filter AuditFilter) ([]AuditRecord, error)
+}
+
+// Options is the data base connection pool settings and the operations timeouts
+type Options struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	// DefaultTimeout is applied to the operations missing in Timeouts, zero means no timeout
+	DefaultTimeout time.Duration
+	Timeouts       map[string]time.Duration
+
+	// NameUniqueness is applied to the created trees without the uniqueness, empty means UniqueGlobal
+	NameUniqueness NameUniqueness
+}
+
+// NestedSetsStorage is a postgres TreeStore implementation
 type NestedSetsStorage struct {
-	DbConnectionString string
-	DbDriver           string
+	db             *sql.DB
+	tree           string
+	defaultTimeout time.Duration
+	timeouts       map[string]time.Duration
+	nameUniqueness NameUniqueness
 }
 
-// GetParents returns parents for the node name
-func (s *NestedSetsStorage) GetParents(name string) ([]string, error) {
-	if name == "" {
-		return []string{}, errors.New("invalid node name")
-	}
+var _ TreeStore = (*NestedSetsStorage)(nil)
 
-	db, err := sql.Open(s.DbDriver, s.DbConnectionString)
+// New opens a long-lived connection pool and returns the storage working through it
+func New(driver string, dsn string, opts Options) (*NestedSetsStorage, error) {
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
-		return []string{}, err
+		return nil, err
 	}
-	defer db.Close()
 
-	query :=
-		`WITH child AS (SELECT ch.node_left, ch.node_right 
-						FROM nodes AS ch WHERE ch.name = $1)
-		SELECT n.name
-		FROM nodes AS n, child 
-		WHERE n.node_left < child.node_left AND n.node_right > child.node_right;`
-	rows, err := db.Query(query, name)
-	if err != nil {
-		log.Println(err)
-		return []string{}, err
+	if opts.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(opts.MaxOpenConns)
+	}
+	if opts.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(opts.MaxIdleConns)
+	}
+	if opts.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(opts.ConnMaxLifetime)
 	}
-	defer rows.Close()
 
-	var result []string
-	for rows.Next() {
-		var nodeName string
-		err := rows.Scan(&nodeName)
-		if err != nil {
-			return []string{}, err
-		}
-		result = append(result, nodeName)
+	if opts.NameUniqueness == "" {
+		opts.NameUniqueness = UniqueGlobal
+	}
+	if !opts.NameUniqueness.valid() {
+		db.Close()
+		return nil, fmt.Errorf("%w: unknown name uniqueness %q", ErrInvalidArgument, opts.NameUniqueness)
 	}
 
-	return result, nil
+	return &NestedSetsStorage{
+		db:             db,
+		tree:           DefaultTree,
+		defaultTimeout: opts.DefaultTimeout,
+		timeouts:       opts.Timeouts,
+		nameUniqueness: opts.NameUniqueness}, nil
+}
+
+// Close closes the connection pool
+func (s *NestedSetsStorage) Close() error {
+	return s.db.Close()
+}
+
+// Tree returns the storage working with the tree name through the same connection pool,
+// an empty name means DefaultTree
+func (s *NestedSetsStorage) Tree(name string) TreeStore {
+	return s.WithTree(name)
 }
 
-// GetChildren returns children for the node name
-func (s *NestedSetsStorage) GetChildren(name string) ([]string, error) {
+// WithTree returns the storage working with the tree name through the same connection pool,
+// an empty name means DefaultTree
+func (s *NestedSetsStorage) WithTree(name string) *NestedSetsStorage {
 	if name == "" {
-		return []string{}, errors.New("invalid node name")
+		name = DefaultTree
 	}
+	scoped := *s
+	scoped.tree = name
+	return &scoped
+}
 
-	db, err := sql.Open(s.DbDriver, s.DbConnectionString)
-	if err != nil {
-		log.Println(err)
-		return []string{}, err
+// GetParents returns parents for the node name ordered from the root
+func (s *NestedSetsStorage) GetParents(name string, opts ReadOptions) ([]PathNode, error) {
+	return s.GetParentsContext(context.Background(), name, opts)
+}
+
+// GetParentsContext returns parents for the node name ordered from the root
+func (s *NestedSetsStorage) GetParentsContext(ctx context.Context, name string, opts ReadOptions) ([]PathNode, error) {
+	if !validName(name) {
+		return []PathNode{}, ErrInvalidName
+	}
+
+	ctx, cancel := s.withTimeout(ctx, OpGetParents)
+	defer cancel()
+
+	nodes, resolve := nodesSource(opts, 3)
+	query := fmt.Sprintf(
+		`WITH child AS (SELECT ch.tree_id, ch.node_left, ch.node_right
+						FROM trees AS t JOIN %[1]s AS ch ON ch.id = %[2]s
+						WHERE t.name = $1)
+		SELECT n.id, n.name
+		FROM %[1]s AS n, child
+		WHERE n.tree_id = child.tree_id
+			AND n.node_left < child.node_left AND n.node_right > child.node_right
+		ORDER BY n.node_left;`, nodes, resolve)
+	return s.queryPath(ctx, name, opts, query, withAsOf(opts, s.tree, name)...)
+}
+
+// GetPath returns parents for the node name ordered from the root,
+// the node itself ends the path if includeSelf is set
+func (s *NestedSetsStorage) GetPath(name string, includeSelf bool) ([]PathNode, error) {
+	return s.GetPathContext(context.Background(), name, includeSelf)
+}
+
+// GetPathContext returns parents for the node name ordered from the root,
+// the node itself ends the path if includeSelf is set
+func (s *NestedSetsStorage) GetPathContext(ctx context.Context, name string, includeSelf bool) ([]PathNode, error) {
+	if !validName(name) {
+		return []PathNode{}, ErrInvalidName
 	}
-	defer db.Close()
+
+	ctx, cancel := s.withTimeout(ctx, OpGetPath)
+	defer cancel()
 
 	query :=
-		`WITH parent AS (SELECT p.node_left, p.node_right 
-						FROM nodes AS p WHERE p.name = $1)
-		SELECT n.name 
-		FROM nodes AS n, parent 
-		WHERE n.node_left > parent.node_left AND n.node_right < parent.node_right;`
-	rows, err := db.Query(query, name)
+		`WITH child AS (SELECT ch.tree_id, ch.node_left, ch.node_right
+						FROM trees AS t JOIN nodes AS ch ON ch.id = resolve_node(t.id, $2)
+						WHERE t.name = $1)
+		SELECT n.id, n.name
+		FROM nodes AS n, child
+		WHERE n.tree_id = child.tree_id
+			AND n.node_left <= child.node_left AND n.node_right >= child.node_right
+			AND ($3 OR n.node_left <> child.node_left)
+		ORDER BY n.node_left;`
+	return s.queryPath(ctx, name, ReadOptions{}, query, s.tree, name, includeSelf)
+}
+
+// RenderPath joins the names of the path nodes with separator
+func RenderPath(path []PathNode, separator string) string {
+	names := make([]string, len(path))
+	for i, node := range path {
+		names[i] = node.Name
+	}
+	return strings.Join(names, separator)
+}
+
+// GetChildren returns children for the node name down to maxDepth levels, zero maxDepth means all descendants
+func (s *NestedSetsStorage) GetChildren(name string, maxDepth int, opts ReadOptions) ([]NestedSetsChild, error) {
+	return s.GetChildrenContext(context.Background(), name, maxDepth, opts)
+}
+
+// GetChildrenContext returns children for the node name down to maxDepth levels, zero maxDepth means all descendants
+func (s *NestedSetsStorage) GetChildrenContext(ctx context.Context, name string, maxDepth int, opts ReadOptions) ([]NestedSetsChild, error) {
+	if !validName(name) {
+		return []NestedSetsChild{}, ErrInvalidName
+	}
+
+	ctx, cancel := s.withTimeout(ctx, OpGetChildren)
+	defer cancel()
+
+	// the descendants are walked by the parent ids level by level down to maxDepth only,
+	// the intervals keep the walk inside the node if the parent ids are broken
+	snapshot, nodes, resolve := nodesSnapshot(opts, 5)
+	query := fmt.Sprintf(
+		`WITH RECURSIVE %[3]s children AS (
+			SELECT n.id, n.name, n.node_left, n.node_right, n.attributes, 1 AS depth
+			FROM trees AS t JOIN %[1]s AS p ON p.id = %[2]s JOIN %[1]s AS n ON n.parent_id = p.id
+			WHERE t.name = $1 AND n.node_left > p.node_left AND n.node_right < p.node_right
+			UNION ALL
+			SELECT n.id, n.name, n.node_left, n.node_right, n.attributes, c.depth + 1
+			FROM children AS c JOIN %[1]s AS n ON n.parent_id = c.id
+			WHERE ($3 <= 0 OR c.depth < $3) AND n.node_left > c.node_left AND n.node_right < c.node_right)
+		SELECT id, name, depth, CASE WHEN $4 THEN attributes END
+		FROM children
+		ORDER BY node_left;`, nodes, resolve, snapshot)
+	rows, err := s.db.QueryContext(ctx, query, withAsOf(opts, s.tree, name, maxDepth, opts.WithAttributes)...)
 	if err != nil {
 		log.Println(err)
-		return []string{}, err
+		return []NestedSetsChild{}, storageError(err)
 	}
 	defer rows.Close()
 
-	var result []string
+	var result []NestedSetsChild
 	for rows.Next() {
-		var nodeName string
-		err := rows.Scan(&nodeName)
+		var child NestedSetsChild
+		err := rows.Scan(&child.ID, &child.Name, &child.Depth, &child.Attributes)
 		if err != nil {
-			return []string{}, err
+			return []NestedSetsChild{}, storageError(err)
+		}
+		result = append(result, child)
+	}
+	err = rows.Err()
+	if err != nil {
+		return []NestedSetsChild{}, storageError(err)
+	}
+
+	if len(result) == 0 {
+		err = s.checkNodeExists(ctx, name, opts)
+		if err != nil {
+			return []NestedSetsChild{}, err
 		}
-		result = append(result, nodeName)
 	}
 
 	return result, nil
 }
 
-// GetWholeTree returns all nodes
-func (s *NestedSetsStorage) GetWholeTree() ([]NestedSetsNode, error) {
-	db, err := sql.Open(s.DbDriver, s.DbConnectionString)
-	if err != nil {
-		log.Println(err)
-		return []NestedSetsNode{}, err
-	}
-	defer db.Close()
+// GetWholeTree returns all nodes of the tree
+func (s *NestedSetsStorage) GetWholeTree(opts ReadOptions) ([]NestedSetsNode, error) {
+	return s.GetWholeTreeContext(context.Background(), opts)
+}
+
+// GetWholeTreeContext returns all nodes of the tree
+func (s *NestedSetsStorage) GetWholeTreeContext(ctx context.Context, opts ReadOptions) ([]NestedSetsNode, error) {
+	ctx, cancel := s.withTimeout(ctx, OpGetWholeTree)
+	defer cancel()
 
-	query := `SELECT name, node_left, node_right
-			  FROM nodes;`
-	rows, err := db.Query(query)
+	nodes, _ := nodesSource(opts, 3)
+	query := fmt.Sprintf(
+		`SELECT n.id, n.name, n.node_left, n.node_right, CASE WHEN $2 THEN n.attributes END
+		FROM %s AS n JOIN trees AS t ON t.id = n.tree_id
+		WHERE t.name = $1;`, nodes)
+	rows, err := s.db.QueryContext(ctx, query, withAsOf(opts, s.tree, opts.WithAttributes)...)
 	if err != nil {
 		log.Println(err)
-		return []NestedSetsNode{}, err
+		return []NestedSetsNode{}, storageError(err)
 	}
 	defer rows.Close()
 
 	var result []NestedSetsNode
 	for rows.Next() {
 		var node NestedSetsNode
-		err := rows.Scan(&node.Name, &node.Left, &node.Right)
+		err := rows.Scan(&node.ID, &node.Name, &node.Left, &node.Right, &node.Attributes)
 		if err != nil {
-			return []NestedSetsNode{}, err
+			return []NestedSetsNode{}, storageError(err)
 		}
 		result = append(result, node)
 	}
+	err = rows.Err()
+	if err != nil {
+		return []NestedSetsNode{}, storageError(err)
+	}
+
+	if len(result) == 0 {
+		err = s.checkTreeExists(ctx)
+		if err != nil {
+			return []NestedSetsNode{}, err
+		}
+	}
 
 	return result, nil
 }
 
-// AddNode adds new child node with name name for parent node with name parent
-func (s *NestedSetsStorage) AddNode(name string, parent string) error {
-	if name == "" || parent == "" {
-		return errors.New("invalid node name")
-	}
+// AddNode adds new child node with name name for parent node with name parent at the position pos
+func (s *NestedSetsStorage) AddNode(name string, parent string, pos Position) error {
+	return s.AddNodeContext(context.Background(), name, parent, pos)
+}
 
-	db, err := sql.Open(s.DbDriver, s.DbConnectionString)
+// AddNodeContext adds new child node with name name for parent node with name parent at the position pos
+func (s *NestedSetsStorage) AddNodeContext(ctx context.Context, name string, parent string, pos Position) error {
+	op, err := addNodeOp(name, parent, pos)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	_, err = s.runOp(ctx, op)
+	return err
+}
+
+// addNodeOp checks the arguments of AddNode and returns the change adding the node
+func addNodeOp(name string, parent string, pos Position) (nodeOp, error) {
+	if !validNewName(name) || !validName(parent) {
+		return nodeOp{}, ErrInvalidName
+	}
+	err := pos.validate()
 	if err != nil {
-		return err
+		return nodeOp{}, err
 	}
 
-	stmt, err := tx.Prepare(`SELECT add_node($1, $2);`)
+	return nodeOp{
+		auditEntry: auditEntry{op: OpAddNode, node: name, args: Attributes{"parent": parent, "position": pos}},
+		run: func(ctx context.Context, tx *sql.Tx, tree int) (opResult, error) {
+			var code, added int
+			err := tx.QueryRowContext(ctx, `SELECT result, COALESCE(node_id, 0) FROM add_node($1, $2, $3, $4, $5);`,
+				tree, name, parent, pos.kind(), pos.Sibling).Scan(&code, &added)
+			if err != nil {
+				return opResult{}, storageError(err)
+			}
+			return opResult{added: added}, resultError(code)
+		}}, nil
+}
+
+// RemoveNode removes node with name name and returns the removed node names,
+// an empty mode means RemovePromote, target is used by RemoveReassign only
+func (s *NestedSetsStorage) RemoveNode(name string, mode RemoveMode, target string) ([]string, error) {
+	return s.RemoveNodeContext(context.Background(), name, mode, target)
+}
+
+// RemoveNodeContext removes node with name name and returns the removed node names,
+// an empty mode means RemovePromote, target is used by RemoveReassign only
+func (s *NestedSetsStorage) RemoveNodeContext(ctx context.Context, name string, mode RemoveMode, target string) ([]string, error) {
+	op, err := removeNodeOp(name, mode, target)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return []string{}, err
 	}
-	defer stmt.Close()
 
-	rows, err := stmt.Query(name, parent)
+	removed, err := s.runOp(ctx, op)
 	if err != nil {
-		tx.Rollback()
-		return err
+		return []string{}, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var result string
-		err := rows.Scan(&result)
+	return removed, nil
+}
+
+// removeNodeOp checks the arguments of RemoveNode and returns the change removing the node
+func removeNodeOp(name string, mode RemoveMode, target string) (nodeOp, error) {
+	if !validName(name) || (mode == RemoveReassign && !validName(target)) {
+		return nodeOp{}, ErrInvalidName
+	}
+	if mode != "" && mode != RemovePromote && mode != RemoveCascade && mode != RemoveReassign {
+		return nodeOp{}, fmt.Errorf("%w: unknown remove mode %q", ErrInvalidArgument, mode)
+	}
+
+	entry := auditEntry{op: OpRemoveNode, node: name, args: Attributes{"mode": string(mode), "target": target}}
+	return nodeOp{auditEntry: entry, run: func(ctx context.Context, tx *sql.Tx, tree int) (opResult, error) {
+		// the removed names are read before the removal, the node may be referred by id
+		removedQuery :=
+			`WITH node AS (SELECT r.node_left, r.node_right
+							FROM nodes AS r WHERE r.id = resolve_node($1, $2))
+			SELECT n.name
+			FROM nodes AS n, node
+			WHERE n.tree_id = $1 AND n.node_left >= node.node_left AND n.node_right <= node.node_right
+				AND ($3 OR n.node_left = node.node_left)
+			ORDER BY n.node_left;`
+		removed, err := queryNames(ctx, tx, removedQuery, tree, name, mode == RemoveCascade)
 		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		if result != "" {
-			tx.Rollback()
-			return errors.New(result)
+			return opResult{}, err
 		}
-	}
 
-	return tx.Commit()
+		switch mode {
+		case RemoveCascade:
+			err = callResult(ctx, tx, `SELECT remove_subtree($1, $2);`, tree, name)
+		case RemoveReassign:
+			err = callResult(ctx, tx, `SELECT reassign_children($1, $2, $3);`, tree, name, target)
+		default:
+			err = callResult(ctx, tx, `SELECT remove_node($1, $2);`, tree, name)
+		}
+		return opResult{removed: removed}, err
+	}}, nil
 }
 
-// RemoveNode removes node with name name
-func (s *NestedSetsStorage) RemoveNode(name string) error {
-	if name == "" {
-		return errors.New("invalid node name")
-	}
+// MoveNode moves node with name name to the position pos among the newParent children,
+// the node children take its place. An empty position kind keeps the legacy placement:
+// the nearest edge of newParent
+func (s *NestedSetsStorage) MoveNode(name string, newParent string, pos Position) error {
+	return s.MoveNodeContext(context.Background(), name, newParent, pos)
+}
 
-	db, err := sql.Open(s.DbDriver, s.DbConnectionString)
+// MoveNodeContext moves node with name name to the position pos among the newParent children,
+// the node children take its place. An empty position kind keeps the legacy placement:
+// the nearest edge of newParent
+func (s *NestedSetsStorage) MoveNodeContext(ctx context.Context, name string, newParent string, pos Position) error {
+	op, err := moveNodeOp(name, newParent, pos)
 	if err != nil {
 		return err
 	}
-	defer db.Close()
 
-	tx, err := db.Begin()
+	_, err = s.runOp(ctx, op)
+	return err
+}
+
+// moveNodeOp checks the arguments of MoveNode and returns the change moving the node
+func moveNodeOp(name string, newParent string, pos Position) (nodeOp, error) {
+	if !validName(name) || !validName(newParent) {
+		return nodeOp{}, ErrInvalidName
+	}
+	err := pos.validate()
 	if err != nil {
-		return err
+		return nodeOp{}, err
 	}
 
-	stmt, err := tx.Prepare(`SELECT remove_node($1);`)
+	return nodeOp{
+		auditEntry: auditEntry{op: OpMoveNode, node: name, args: Attributes{"parent": newParent, "position": pos}},
+		run:        callOp(`SELECT move_node($1, $2, $3, $4, $5);`, name, newParent, string(pos.Kind), pos.Sibling)}, nil
+}
+
+// MoveSubtree moves node with name name together with all its descendants
+// to the position pos among the newParent children
+func (s *NestedSetsStorage) MoveSubtree(name string, newParent string, pos Position) error {
+	return s.MoveSubtreeContext(context.Background(), name, newParent, pos)
+}
+
+// MoveSubtreeContext moves node with name name together with all its descendants
+// to the position pos among the newParent children
+func (s *NestedSetsStorage) MoveSubtreeContext(ctx context.Context, name string, newParent string, pos Position) error {
+	op, err := moveSubtreeOp(name, newParent, pos)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-	defer stmt.Close()
 
-	rows, err := stmt.Query(name)
+	_, err = s.runOp(ctx, op)
+	return err
+}
+
+// moveSubtreeOp checks the arguments of MoveSubtree and returns the change moving the subtree
+func moveSubtreeOp(name string, newParent string, pos Position) (nodeOp, error) {
+	if !validName(name) || !validName(newParent) {
+		return nodeOp{}, ErrInvalidName
+	}
+	err := pos.validate()
 	if err != nil {
-		tx.Rollback()
-		return err
+		return nodeOp{}, err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var result string
-		err := rows.Scan(&result)
-		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		if result != "" {
-			tx.Rollback()
-			return errors.New(result)
+	return nodeOp{
+		auditEntry: auditEntry{op: OpMoveSubtree, node: name, args: Attributes{"parent": newParent, "position": pos}},
+		run:        callOp(`SELECT move_subtree($1, $2, $3, $4, $5);`, name, newParent, pos.kind(), pos.Sibling)}, nil
+}
+
+// ReorderChildren places the children of the node parent in the order of names,
+// names must list every child of parent exactly once
+func (s *NestedSetsStorage) ReorderChildren(parent string, names []string) error {
+	return s.ReorderChildrenContext(context.Background(), parent, names)
+}
+
+// ReorderChildrenContext places the children of the node parent in the order of names,
+// names must list every child of parent exactly once
+func (s *NestedSetsStorage) ReorderChildrenContext(ctx context.Context, parent string, names []string) error {
+	if !validName(parent) {
+		return ErrInvalidName
+	}
+	for _, name := range names {
+		if !validName(name) {
+			return ErrInvalidName
 		}
 	}
 
-	return tx.Commit()
+	_, err := s.runOp(ctx, nodeOp{
+		auditEntry: auditEntry{op: OpReorder, node: parent, args: Attributes{"children": names}},
+		run:        callOp(`SELECT reorder_children($1, $2, $3);`, parent, pq.Array(names))})
+	return err
 }
 
-// MoveNode moves node with name name
-func (s *NestedSetsStorage) MoveNode(name string, newParent string) error {
-	if name == "" || newParent == "" {
-		return errors.New("invalid node name")
+// SortChildren sorts the children of the node parent
+func (s *NestedSetsStorage) SortChildren(parent string, order SortOrder) error {
+	return s.SortChildrenContext(context.Background(), parent, order)
+}
+
+// SortChildrenContext sorts the children of the node parent
+func (s *NestedSetsStorage) SortChildrenContext(ctx context.Context, parent string, order SortOrder) error {
+	if !validName(parent) {
+		return ErrInvalidName
 	}
 
-	db, err := sql.Open(s.DbDriver, s.DbConnectionString)
-	if err != nil {
-		return err
+	ctx, cancel := s.withTimeout(ctx, OpSortChildren)
+	defer cancel()
+
+	direction := "ASC"
+	if order.Descending {
+		direction = "DESC"
 	}
-	defer db.Close()
+	// the attribute values are compared as json, so the numbers are ordered as numbers,
+	// the children missing the attribute follow the others ordered by their names
+	attribute := order.Key
+	if attribute == "name" {
+		attribute = ""
+	}
+	childrenQuery := fmt.Sprintf(
+		`WITH parent AS (SELECT p.node_left, p.node_right
+						FROM nodes AS p WHERE p.id = resolve_node($1, $2))
+		SELECT 'id:' || c.id
+		FROM nodes AS c, parent
+		WHERE c.tree_id = $1 AND c.node_left > parent.node_left AND c.node_right < parent.node_right
+			AND NOT EXISTS (SELECT 1 FROM nodes AS a
+							WHERE a.tree_id = $1 AND a.node_left > parent.node_left
+								AND a.node_left < c.node_left AND a.node_right > c.node_right)
+		ORDER BY c.attributes->NULLIF($3, '') %[1]s NULLS LAST, c.name %[1]s;`, direction)
+
+	entry := auditEntry{op: OpSortChildren, node: parent, args: Attributes{"key": order.Key, "descending": order.Descending}}
+	return s.inTx(ctx, func(tx *sql.Tx, tree int) error {
+		return audited(ctx, tx, tree, entry, func() (int, error) {
+			refs, err := queryNames(ctx, tx, childrenQuery, tree, parent, attribute)
+			if err != nil {
+				return 0, err
+			}
+			return 0, callResult(ctx, tx, `SELECT reorder_children($1, $2, $3);`, tree, parent, pq.Array(refs))
+		})
+	})
+}
+
+// RenameNode renames node with name name
+func (s *NestedSetsStorage) RenameNode(name string, newName string) error {
+	return s.RenameNodeContext(context.Background(), name, newName)
+}
 
-	tx, err := db.Begin()
+// RenameNodeContext renames node with name name
+func (s *NestedSetsStorage) RenameNodeContext(ctx context.Context, name string, newName string) error {
+	op, err := renameNodeOp(name, newName)
 	if err != nil {
 		return err
 	}
 
-	stmt, err := tx.Prepare("SELECT move_node($1,$2);")
+	_, err = s.runOp(ctx, op)
+	return err
+}
+
+// renameNodeOp checks the arguments of RenameNode and returns the change renaming the node
+func renameNodeOp(name string, newName string) (nodeOp, error) {
+	if !validName(name) || !validNewName(newName) {
+		return nodeOp{}, ErrInvalidName
+	}
+
+	return nodeOp{
+		auditEntry: auditEntry{op: OpRenameNode, node: name, args: Attributes{"new_name": newName}},
+		run:        callOp(`SELECT rename_node($1, $2, $3);`, name, newName)}, nil
+}
+
+// AddRoot adds the first node of the tree or creates a new root
+func (s *NestedSetsStorage) AddRoot(name string) error {
+	return s.AddRootContext(context.Background(), name)
+}
+
+// AddRootContext adds the first node of the tree or creates a new root
+func (s *NestedSetsStorage) AddRootContext(ctx context.Context, name string) error {
+	op, err := addRootOp(name)
 	if err != nil {
-		tx.Rollback()
 		return err
 	}
-	defer stmt.Close()
 
-	rows, err := stmt.Query(name, newParent)
+	_, err = s.runOp(ctx, op)
+	return err
+}
+
+// addRootOp checks the arguments of AddRoot and returns the change adding the root
+func addRootOp(name string) (nodeOp, error) {
+	if !validNewName(name) {
+		return nodeOp{}, ErrInvalidName
+	}
+
+	rootQuery := `WITH max_right AS
+	(SELECT MAX(m.node_right) AS max_r
+	FROM nodes AS m
+	WHERE m.tree_id = $1),
+	null_check AS
+	(SELECT
+		CASE WHEN max_r IS NOT NULL
+		THEN max_r ELSE -1
+		END mx
+	FROM max_right)
+    INSERT INTO nodes
+	(tree_id, name, node_left, node_right)
+	VALUES ($1, $2, (SELECT mx FROM null_check) + 1, (SELECT mx FROM null_check) + 2)
+	RETURNING id;`
+
+	return nodeOp{auditEntry: auditEntry{op: OpAddRoot, node: name}, run: func(ctx context.Context, tx *sql.Tx, tree int) (opResult, error) {
+		var taken bool
+		err := tx.QueryRowContext(ctx, `SELECT name_taken($1, NULL, $2, -1, 2147483647);`, tree, name).Scan(&taken)
+		if err != nil {
+			return opResult{}, storageError(err)
+		}
+		if taken {
+			return opResult{}, ErrNodeExists
+		}
+
+		var added int
+		err = tx.QueryRowContext(ctx, rootQuery, tree, name).Scan(&added)
+		if err == sql.ErrNoRows {
+			return opResult{}, ErrNodeExists
+		}
+		if err != nil {
+			return opResult{}, storageError(err)
+		}
+
+		return opResult{added: added}, nil
+	}}, nil
+}
+
+// withTimeout applies the configured timeout of the operation op to ctx
+func (s *NestedSetsStorage) withTimeout(ctx context.Context, op string) (context.Context, context.CancelFunc) {
+	timeout, ok := s.timeouts[op]
+	if !ok {
+		timeout = s.defaultTimeout
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// queryPath runs query returning the id and name columns of the path of the node name,
+// an empty result is checked for the node existence at opts.AsOf
+func (s *NestedSetsStorage) queryPath(ctx context.Context, name string, opts ReadOptions, query string, args ...interface{}) ([]PathNode, error) {
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		tx.Rollback()
-		return err
+		log.Println(err)
+		return []PathNode{}, storageError(err)
 	}
 	defer rows.Close()
 
+	var result []PathNode
 	for rows.Next() {
-		var result string
-		err := rows.Scan(&result)
+		var node PathNode
+		err := rows.Scan(&node.ID, &node.Name)
 		if err != nil {
-			tx.Rollback()
-			return err
-		}
-		if result != "" {
-			tx.Rollback()
-			return errors.New(result)
+			return []PathNode{}, storageError(err)
 		}
+		result = append(result, node)
+	}
+	err = rows.Err()
+	if err != nil {
+		return []PathNode{}, storageError(err)
 	}
 
-	return tx.Commit()
+	if len(result) == 0 {
+		err = s.checkNodeExists(ctx, name, opts)
+		if err != nil {
+			return []PathNode{}, err
+		}
+	}
+	return result, nil
 }
 
-// RenameNode renames node with name name
-func (s *NestedSetsStorage) RenameNode(name string, newName string) error {
-	if name == "" || newName == "" {
-		return errors.New("invalid node name")
+// checkNodeExists returns ErrTreeNotFound if there is no storage tree,
+// ErrNodeNotFound if there is no node name in it at opts.AsOf and ErrAmbiguousName if there are several
+func (s *NestedSetsStorage) checkNodeExists(ctx context.Context, name string, opts ReadOptions) error {
+	_, resolve := nodesSource(opts, 3)
+	query := fmt.Sprintf(
+		`SELECT EXISTS (SELECT 1 FROM trees WHERE name = $1),
+				(SELECT %s FROM trees AS t WHERE t.name = $1);`, resolve)
+	var treeExists bool
+	var nodeID sql.NullInt64
+	err := s.db.QueryRowContext(ctx, query, withAsOf(opts, s.tree, name)...).Scan(&treeExists, &nodeID)
+	if err != nil {
+		return storageError(err)
+	}
+	if !treeExists {
+		return ErrTreeNotFound
 	}
+	if !nodeID.Valid {
+		return ErrNodeNotFound
+	}
+	if nodeID.Int64 == 0 {
+		return ErrAmbiguousName
+	}
+	return nil
+}
 
-	db, err := sql.Open(s.DbDriver, s.DbConnectionString)
+// resolveNode returns the id of the node name in the tree,
+// ErrNodeNotFound if there is no such node and ErrAmbiguousName if there are several
+func resolveNode(ctx context.Context, q querier, tree int, name string) (int, error) {
+	var id sql.NullInt64
+	err := q.QueryRowContext(ctx, `SELECT resolve_node($1, $2);`, tree, name).Scan(&id)
 	if err != nil {
-		return err
+		return 0, storageError(err)
+	}
+	if !id.Valid {
+		return 0, ErrNodeNotFound
 	}
-	defer db.Close()
+	if id.Int64 == 0 {
+		return 0, ErrAmbiguousName
+	}
+	return int(id.Int64), nil
+}
 
-	renameQuery := `UPDATE nodes 
-					SET name = $1 
-					WHERE name = $2;`
-	result, err := db.Exec(renameQuery, newName, name)
+// checkTreeExists returns ErrTreeNotFound if there is no storage tree
+func (s *NestedSetsStorage) checkTreeExists(ctx context.Context) error {
+	var exists bool
+	err := s.db.QueryRowContext(ctx, `SELECT EXISTS (SELECT 1 FROM trees WHERE name = $1);`, s.tree).Scan(&exists)
 	if err != nil {
-		return err
+		return storageError(err)
 	}
+	if !exists {
+		return ErrTreeNotFound
+	}
+	return nil
+}
 
-	count, err := result.RowsAffected()
+// querier is either *sql.DB or *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// queryNames runs query returning a single name column
+func queryNames(ctx context.Context, q querier, query string, args ...interface{}) ([]string, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
 	if err != nil {
+		log.Println(err)
+		return []string{}, storageError(err)
+	}
+	defer rows.Close()
+
+	var result []string
+	for rows.Next() {
+		var nodeName string
+		err := rows.Scan(&nodeName)
+		if err != nil {
+			return []string{}, storageError(err)
+		}
+		result = append(result, nodeName)
+	}
+
+	return result, storageError(rows.Err())
+}
+
+// nodeOp is a change of the tree nodes run in a transaction with the tree id
+type nodeOp struct {
+	auditEntry
+	run func(ctx context.Context, tx *sql.Tx, tree int) (opResult, error)
+}
+
+// opResult is what a change of the tree nodes did
+type opResult struct {
+	added   int      // the id of the added node if any
+	removed []string // the names of the removed nodes if any
+}
+
+// apply runs the change recording it to the audit log and returns the names of the removed nodes
+func (op nodeOp) apply(ctx context.Context, tx *sql.Tx, tree int) ([]string, error) {
+	var result opResult
+	err := audited(ctx, tx, tree, op.auditEntry, func() (int, error) {
+		var err error
+		result, err = op.run(ctx, tx, tree)
+		return result.added, err
+	})
+	return result.removed, err
+}
+
+// runOp runs the change in its own transaction with the timeout of its operation
+func (s *NestedSetsStorage) runOp(ctx context.Context, op nodeOp) ([]string, error) {
+	ctx, cancel := s.withTimeout(ctx, op.op)
+	defer cancel()
+
+	var removed []string
+	err := s.inTx(ctx, func(tx *sql.Tx, tree int) error {
+		var err error
+		removed, err = op.apply(ctx, tx, tree)
 		return err
+	})
+	return removed, err
+}
+
+// callOp returns the run of a change calling the stored function query,
+// the tree id is the first function argument followed by args
+func callOp(query string, args ...interface{}) func(ctx context.Context, tx *sql.Tx, tree int) (opResult, error) {
+	return func(ctx context.Context, tx *sql.Tx, tree int) (opResult, error) {
+		return opResult{}, callResult(ctx, tx, query, append([]interface{}{tree}, args...)...)
 	}
-	if count != 1 {
-		return errors.New("rename failed: node not found")
+}
+
+// callResult calls the stored function query returning a result code
+func callResult(ctx context.Context, tx *sql.Tx, query string, args ...interface{}) error {
+	var code int
+	err := tx.QueryRowContext(ctx, query, args...).Scan(&code)
+	if err != nil {
+		return storageError(err)
 	}
+	return resultError(code)
+}
 
-	return err
+// inTx runs fn in a transaction with the storage tree id holding the tree lock,
+// the transaction is rolled back if fn fails and retried with a backoff
+// if it fails on a serialization failure or a deadlock, so fn must be safe to run again
+func (s *NestedSetsStorage) inTx(ctx context.Context, fn func(tx *sql.Tx, tree int) error) error {
+	backoff := _TX_RETRY_BACKOFF
+	for attempt := 1; ; attempt++ {
+		err := s.tryTx(ctx, fn)
+		if err == nil || attempt == _MAX_TX_ATTEMPTS || !retryable(err) {
+			return err
+		}
+
+		// the jitter keeps the retrying transactions apart
+		delay := backoff/2 + time.Duration(rand.Int63n(int64(backoff)))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		backoff *= 2
+	}
 }
 
-// AddRoot adds the first node or creates a new root
-func (s *NestedSetsStorage) AddRoot(name string) error {
-	if name == "" {
-		return errors.New("invalid node name")
+// tryTx runs fn in a single transaction attempt, the mutations of a tree
+// are serialized by the transaction level advisory lock of the tree
+func (s *NestedSetsStorage) tryTx(ctx context.Context, fn func(tx *sql.Tx, tree int) error) error {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return storageError(err)
 	}
 
-	db, err := sql.Open(s.DbDriver, s.DbConnectionString)
+	version, err := s.lockedTx(ctx, tx, fn)
 	if err != nil {
+		tx.Rollback()
 		return err
 	}
-	defer db.Close()
 
-	rootQuery := `WITH max_right AS 
-	(SELECT MAX(m.node_right) AS max_r 
-	FROM nodes AS m), 
-	null_check AS 
-	(SELECT 
-		CASE WHEN max_r IS NOT NULL 
-		THEN max_r ELSE -1 
-		END mx 
-	FROM max_right)
-    INSERT INTO nodes
-	(name, node_left, node_right) 
-	VALUES ($1, (SELECT mx FROM null_check) + 1, (SELECT mx FROM null_check) + 2);`
+	err = tx.Commit()
+	if err != nil {
+		return storageError(err)
+	}
+	if check, ok := ctx.Value(versionKey{}).(*VersionCheck); ok {
+		check.Committed = version
+	}
+	return nil
+}
 
-	result, err := db.Exec(rootQuery, name)
+// lockedTx takes the tree lock, checks the tree version expected by the context,
+// runs fn with the tree id and returns the next tree version
+func (s *NestedSetsStorage) lockedTx(ctx context.Context, tx *sql.Tx, fn func(tx *sql.Tx, tree int) error) (int64, error) {
+	var tree int
+	err := tx.QueryRowContext(ctx, `SELECT id FROM trees WHERE name = $1;`, s.tree).Scan(&tree)
+	if err == sql.ErrNoRows {
+		return 0, ErrTreeNotFound
+	}
 	if err != nil {
-		return err
+		return 0, storageError(err)
 	}
 
-	count, err := result.RowsAffected()
+	// the version is read by the next statement to see the commits made while waiting for the lock,
+	// the node history records the changes of the transaction at the time the lock is taken
+	_, err = tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1, $2);`, _TREE_LOCK_CLASS, tree)
 	if err != nil {
-		return err
+		return 0, storageError(err)
 	}
-	if count != 1 {
-		return errors.New("add root failde: node already exists")
+	var version int64
+	versionQuery := `SELECT t.version
+					 FROM trees AS t, set_config('treestorage.changed_at', clock_timestamp()::text, true)
+					 WHERE t.id = $1;`
+	err = tx.QueryRowContext(ctx, versionQuery, tree).Scan(&version)
+	if err == sql.ErrNoRows {
+		// the tree was deleted while waiting for the lock
+		return 0, ErrTreeNotFound
+	}
+	if err != nil {
+		return 0, storageError(err)
+	}
+	if check, ok := ctx.Value(versionKey{}).(*VersionCheck); ok && check.Expected >= 0 && check.Expected != version {
+		return 0, fmt.Errorf("%w: the tree is at version %d", ErrVersionMismatch, version)
 	}
 
-	return err
+	err = fn(tx, tree)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.QueryRowContext(ctx, `UPDATE trees SET version = version + 1 WHERE id = $1 RETURNING version;`, tree).
+		Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, ErrTreeNotFound
+	}
+	return version, storageError(err)
+}
+
+// validName checks the node name or reference fits the nodes.name column
+func validName(name string) bool {
+	return name != "" && utf8.RuneCountInString(name) <= _MAX_NAME_LENGTH
+}
+
+// validNewName checks the name of a new or renamed node, it can not look like a reference by id
+func validNewName(name string) bool {
+	return validName(name) && !idRef.MatchString(name)
+}
+
+// valid checks the uniqueness is one of the known scopes
+func (u NameUniqueness) valid() bool {
+	return u == UniqueGlobal || u == UniquePerParent || u == UniqueNone
 }
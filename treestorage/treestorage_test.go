@@ -3,11 +3,16 @@ package treestorage_test
 import (
 	"NestedSetsStorage/configs"
 	"NestedSetsStorage/treestorage"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/BurntSushi/toml"
 	_ "github.com/lib/pq"
@@ -65,15 +70,68 @@ func TestNestedSetsStorage_GetParents(t *testing.T) {
 		},
 	}
 
-	s := &treestorage.NestedSetsStorage{
-		DbConnectionString: dbConnectionString,
-		DbDriver:           dbDriver,
+	s := newTestStorage()
+	defer s.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := s.GetParents(tt.args.name, treestorage.ReadOptions{})
+			assert.Equal(t, tt.want, pathNames(got))
+		})
+	}
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_GetPath(t *testing.T) {
+	refillTestData()
+
+	type args struct {
+		name        string
+		includeSelf bool
+	}
+	tests := []struct {
+		name     string
+		args     args
+		want     []string
+		wantPath string
+		wantErr  error
+	}{
+		{
+			name:    "getting path for not existing node",
+			args:    args{"Заместитель директора", false},
+			want:    []string{},
+			wantErr: treestorage.ErrNodeNotFound,
+		},
+		{
+			name:     "getting path for root",
+			args:     args{"Директор", false},
+			want:     []string{},
+			wantPath: "",
+		},
+		{
+			name:     "getting path for node",
+			args:     args{"Ученики", false},
+			want:     []string{"Директор", "Совет лицея", "Ученическое самоуправление"},
+			wantPath: "Директор / Совет лицея / Ученическое самоуправление",
+		},
+		{
+			name:     "getting path for node including the node",
+			args:     args{"Служба сопровождения", true},
+			want:     []string{"Директор", "Заместитель директора по ВР", "Служба сопровождения"},
+			wantPath: "Директор / Заместитель директора по ВР / Служба сопровождения",
+		},
 	}
 
+	s := newTestStorage()
+	defer s.Close()
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, _ := s.GetParents(tt.args.name)
-			assert.ElementsMatch(t, tt.want, got)
+			got, err := s.GetPath(tt.args.name, tt.args.includeSelf)
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			assert.Equal(t, tt.want, pathNames(got))
+			assert.Equal(t, tt.wantPath, treestorage.RenderPath(got, treestorage.DefaultPathSeparator))
 		})
 	}
 
@@ -118,15 +176,72 @@ func TestNestedSetsStorage_GetChildren(t *testing.T) {
 		},
 	}
 
-	s := &treestorage.NestedSetsStorage{
-		DbConnectionString: dbConnectionString,
-		DbDriver:           dbDriver,
+	s := newTestStorage()
+	defer s.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, _ := s.GetChildren(tt.args.name, 0, treestorage.ReadOptions{})
+			assert.ElementsMatch(t, tt.want, childNames(got))
+		})
+	}
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_GetChildrenDepth(t *testing.T) {
+	refillTestData()
+
+	type args struct {
+		name     string
+		maxDepth int
+	}
+	tests := []struct {
+		name string
+		args args
+		want []treestorage.NestedSetsChild
+	}{
+		{
+			name: "getting direct children for node",
+			args: args{"Совет лицея", 1},
+			want: []treestorage.NestedSetsChild{
+				{Name: "Благотворительный фонд \"Развитие школы\"", Depth: 1},
+				{Name: "Ученическое самоуправление", Depth: 1},
+			},
+		},
+		{
+			name: "getting all children for node",
+			args: args{"Совет лицея", 0},
+			want: []treestorage.NestedSetsChild{
+				{Name: "Благотворительный фонд \"Развитие школы\"", Depth: 1},
+				{Name: "Ученическое самоуправление", Depth: 1},
+				{Name: "Ученики", Depth: 2},
+			},
+		},
+		{
+			name: "getting direct children for root",
+			args: args{"Директор", 1},
+			want: []treestorage.NestedSetsChild{
+				{Name: "Заместитель директора по АХЧ", Depth: 1},
+				{Name: "Совет лицея", Depth: 1},
+				{Name: "Заместитель директора по информатизации", Depth: 1},
+				{Name: "Заместитель директора по ВР", Depth: 1},
+				{Name: "Бухгалтерия", Depth: 1},
+				{Name: "Педагогический совет", Depth: 1},
+				{Name: "Заместитель директора по УВР", Depth: 1},
+				{Name: "Научно-методический совет", Depth: 1},
+			},
+		},
 	}
 
+	s := newTestStorage()
+	defer s.Close()
+
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, _ := s.GetChildren(tt.args.name)
-			assert.ElementsMatch(t, tt.want, got)
+			got, err := s.GetChildren(tt.args.name, tt.args.maxDepth, treestorage.ReadOptions{})
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, withoutChildIDs(got))
 		})
 	}
 
@@ -147,14 +262,12 @@ func TestNestedSetsStorage_GetWholeTree(t *testing.T) {
 		},
 	}
 
-	s := &treestorage.NestedSetsStorage{
-		DbConnectionString: dbConnectionString,
-		DbDriver:           dbDriver,
-	}
+	s := newTestStorage()
+	defer s.Close()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			got, _ := s.GetWholeTree()
+			got := wholeTree(s)
 			assert.ElementsMatch(t, tt.want, got)
 		})
 	}
@@ -162,6 +275,69 @@ func TestNestedSetsStorage_GetWholeTree(t *testing.T) {
 	clearTestDataFromDb()
 }
 
+func TestNestedSetsStorage_GetNodesPage(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	var got []treestorage.NestedSetsNode
+	pages := 0
+	cursor := ""
+	for {
+		page, err := s.GetNodesPage(cursor, 5, treestorage.ReadOptions{})
+		assert.NoError(t, err)
+		assert.LessOrEqual(t, len(page.Nodes), 5)
+		got = append(got, page.Nodes...)
+		pages++
+		if page.NextCursor == "" {
+			break
+		}
+		cursor = page.NextCursor
+	}
+
+	all, _ := s.GetWholeTree(treestorage.ReadOptions{})
+	assert.Equal(t, (len(all)+4)/5, pages)
+	assert.ElementsMatch(t, all, got)
+	for i := 1; i < len(got); i++ {
+		assert.Less(t, got[i-1].Left, got[i].Left)
+	}
+
+	_, err := s.GetNodesPage("not a cursor", 5, treestorage.ReadOptions{})
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidArgument), err)
+	_, err = s.GetNodesPage("", 1000000, treestorage.ReadOptions{})
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidArgument), err)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_WalkWholeTree(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	var got []treestorage.NestedSetsNode
+	err := s.WalkWholeTree(treestorage.ReadOptions{}, func(node treestorage.NestedSetsNode) error {
+		got = append(got, node)
+		return nil
+	})
+	assert.NoError(t, err)
+	all, _ := s.GetWholeTree(treestorage.ReadOptions{})
+	assert.ElementsMatch(t, all, got)
+
+	stop := errors.New("stop")
+	walked := 0
+	err = s.WalkWholeTree(treestorage.ReadOptions{}, func(node treestorage.NestedSetsNode) error {
+		walked++
+		return stop
+	})
+	assert.True(t, errors.Is(err, stop), err)
+	assert.Equal(t, 1, walked)
+
+	clearTestDataFromDb()
+}
+
 func TestNestedSetsStorage_AddNode(t *testing.T) {
 	refillTestData()
 	defaultNodes := createTestNodes()
@@ -171,29 +347,34 @@ func TestNestedSetsStorage_AddNode(t *testing.T) {
 		parent string
 	}
 	tests := []struct {
-		name string
-		args args
-		want []treestorage.NestedSetsNode
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
 	}{
 		{
-			name: "adding existing nodes",
-			args: args{"Совет лицея", "Заместитель директора по ВР"},
-			want: defaultNodes,
+			name:    "adding existing nodes",
+			args:    args{"Совет лицея", "Заместитель директора по ВР"},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrNodeExists,
 		},
 		{
-			name: "adding invalid parent nodes case 1",
-			args: args{"Совет лицея", "Заместитель директора"},
-			want: defaultNodes,
+			name:    "adding invalid parent nodes case 1",
+			args:    args{"Совет лицея", "Заместитель директора"},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrParentNotFound,
 		},
 		{
-			name: "adding invalid parent nodes case 2",
-			args: args{"Совет лицея", ""},
-			want: defaultNodes,
+			name:    "adding invalid parent nodes case 2",
+			args:    args{"Совет лицея", ""},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrInvalidName,
 		},
 		{
-			name: "adding empty name nodes",
-			args: args{"", "Совет лицея"},
-			want: defaultNodes,
+			name:    "adding empty name nodes",
+			args:    args{"", "Совет лицея"},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrInvalidName,
 		},
 		{
 			name: "addNodeCase1",
@@ -212,15 +393,14 @@ func TestNestedSetsStorage_AddNode(t *testing.T) {
 		},
 	}
 
-	s := &treestorage.NestedSetsStorage{
-		DbConnectionString: dbConnectionString,
-		DbDriver:           dbDriver,
-	}
+	s := newTestStorage()
+	defer s.Close()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s.AddNode(tt.args.name, tt.args.parent)
-			got, _ := s.GetWholeTree()
+			err := s.AddNode(tt.args.name, tt.args.parent, treestorage.Position{})
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			got := wholeTree(s)
 			assert.ElementsMatch(t, tt.want, got)
 		})
 	}
@@ -228,54 +408,1361 @@ func TestNestedSetsStorage_AddNode(t *testing.T) {
 	clearTestDataFromDb()
 }
 
-func TestNestedSetsStorage_RemoveNode(t *testing.T) {
-	refillTestData()
+func TestNestedSetsStorage_AddNodePosition(t *testing.T) {
 	defaultNodes := createTestNodes()
 
 	type args struct {
-		name string
+		name   string
+		parent string
+		pos    treestorage.Position
 	}
 	tests := []struct {
-		name string
-		args args
-		want []treestorage.NestedSetsNode
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
 	}{
 		{
-			name: "removing not existing nodes",
-			args: args{"Психолог"},
-			want: defaultNodes,
+			name:    "adding before a sibling of another parent",
+			args:    args{"Психолог", "Заместитель директора по ВР", treestorage.Position{treestorage.PositionBefore, "Бухгалтерия"}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrSiblingNotFound,
 		},
 		{
-			name: "removing invalid name nodes",
-			args: args{""},
-			want: defaultNodes,
+			name:    "adding after a not direct child",
+			args:    args{"Психолог", "Совет лицея", treestorage.Position{treestorage.PositionAfter, "Ученики"}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrSiblingNotFound,
 		},
 		{
-			name: "removeNodeCase1",
-			args: args{"Служба сопровождения"},
-			want: removeNodeCase1(),
+			name:    "adding with unknown position",
+			args:    args{"Психолог", "Совет лицея", treestorage.Position{Kind: "middle"}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrInvalidArgument,
 		},
 		{
-			name: "removeNodeCase2",
-			args: args{"Совет лицея"},
-			want: removeNodeCase2(),
+			name: "adding as the first child",
+			args: args{"Психолог", "Заместитель директора по ВР", treestorage.Position{Kind: treestorage.PositionFirst}},
+			want: addNodePositionCase1(),
+		},
+		{
+			name: "adding as the last child",
+			args: args{"Психолог", "Заместитель директора по ВР", treestorage.Position{Kind: treestorage.PositionLast}},
+			want: addNodePositionCase3(),
+		},
+		{
+			name: "adding before a sibling",
+			args: args{"Психолог", "Заместитель директора по ВР", treestorage.Position{treestorage.PositionBefore, "Методическое объединение педагогов дополнительного образования"}},
+			want: addNodePositionCase2(),
+		},
+		{
+			name: "adding after a sibling",
+			args: args{"Психолог", "Заместитель директора по ВР", treestorage.Position{treestorage.PositionAfter, "Служба сопровождения"}},
+			want: addNodePositionCase2(),
+		},
+		{
+			name: "adding after the last sibling",
+			args: args{"Психолог", "Заместитель директора по ВР", treestorage.Position{treestorage.PositionAfter, "Методическое объединение классных руководителей"}},
+			want: addNodePositionCase3(),
+		},
+	}
+
+	s := newTestStorage()
+	defer s.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refillTestData()
+			err := s.AddNode(tt.args.name, tt.args.parent, tt.args.pos)
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			got := wholeTree(s)
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+
+	clearTestDataFromDb()
+}
+
+func TestParsePosition(t *testing.T) {
+	tests := []struct {
+		name    string
+		arg     string
+		want    treestorage.Position
+		wantErr error
+	}{
+		{"empty position", "", treestorage.Position{Kind: treestorage.PositionLast}, nil},
+		{"first position", "first", treestorage.Position{Kind: treestorage.PositionFirst}, nil},
+		{"last position", "last", treestorage.Position{Kind: treestorage.PositionLast}, nil},
+		{"before position", "before:Бухгалтерия", treestorage.Position{treestorage.PositionBefore, "Бухгалтерия"}, nil},
+		{"after position", "after:Совет лицея", treestorage.Position{treestorage.PositionAfter, "Совет лицея"}, nil},
+		{"sibling with a colon", "after:a:b", treestorage.Position{treestorage.PositionAfter, "a:b"}, nil},
+		{"missing sibling", "before:", treestorage.Position{}, treestorage.ErrInvalidArgument},
+		{"unknown position", "middle", treestorage.Position{}, treestorage.ErrInvalidArgument},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := treestorage.ParsePosition(tt.arg)
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func TestBatchOpJSON(t *testing.T) {
+	var ops []treestorage.BatchOp
+	data := `[{"op": "add", "name": "Психолог", "parent": "Служба сопровождения", "position": "before:Логопед"},
+		{"op": "move", "name": "Совет лицея", "parent": "Директор", "subtree": true}]`
+	assert.NoError(t, json.Unmarshal([]byte(data), &ops))
+	assert.Equal(t, []treestorage.BatchOp{
+		{Op: treestorage.BatchAdd, Name: "Психолог", Parent: "Служба сопровождения",
+			Position: treestorage.Position{Kind: treestorage.PositionBefore, Sibling: "Логопед"}},
+		{Op: treestorage.BatchMove, Name: "Совет лицея", Parent: "Директор", Subtree: true},
+	}, ops)
+
+	err := json.Unmarshal([]byte(`[{"op": "add", "position": "middle"}]`), &ops)
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidArgument), err)
+}
+
+func TestBuildTree(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []treestorage.NestedSetsNode
+		want  []treestorage.TreeNode
+	}{
+		{
+			name:  "empty tree",
+			nodes: []treestorage.NestedSetsNode{},
+			want:  []treestorage.TreeNode{},
+		},
+		{
+			name: "forest in any order",
+			nodes: []treestorage.NestedSetsNode{
+				{ID: 4, Name: "Ученики", Left: 2, Right: 3},
+				{ID: 5, Name: "Директор колледжа", Left: 6, Right: 7},
+				{ID: 1, Name: "Директор", Left: 0, Right: 5},
+				{ID: 3, Name: "Совет лицея", Left: 1, Right: 4},
+			},
+			want: []treestorage.TreeNode{
+				{ID: 1, Name: "Директор", Children: []treestorage.TreeNode{
+					{ID: 3, Name: "Совет лицея", Children: []treestorage.TreeNode{
+						{ID: 4, Name: "Ученики", Children: []treestorage.TreeNode{}},
+					}},
+				}},
+				{ID: 5, Name: "Директор колледжа", Children: []treestorage.TreeNode{}},
+			},
+		},
+		{
+			name: "subtree with siblings",
+			nodes: []treestorage.NestedSetsNode{
+				{ID: 3, Name: "Совет лицея", Left: 11, Right: 16},
+				{ID: 6, Name: "Ученическое самоуправление", Left: 14, Right: 15},
+				{ID: 7, Name: "Благотворительный фонд", Left: 12, Right: 13},
+			},
+			want: []treestorage.TreeNode{
+				{ID: 3, Name: "Совет лицея", Children: []treestorage.TreeNode{
+					{ID: 7, Name: "Благотворительный фонд", Children: []treestorage.TreeNode{}},
+					{ID: 6, Name: "Ученическое самоуправление", Children: []treestorage.TreeNode{}},
+				}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, treestorage.BuildTree(tt.nodes))
+		})
+	}
+}
+
+func TestVerifyNodes(t *testing.T) {
+	tests := []struct {
+		name  string
+		nodes []treestorage.NestedSetsNode
+		want  []treestorage.IssueKind
+	}{
+		{
+			name:  "empty tree",
+			nodes: []treestorage.NestedSetsNode{},
+		},
+		{
+			name:  "valid tree",
+			nodes: createTestNodes(),
+		},
+		{
+			name:  "valid forest",
+			nodes: addingRootCase(),
+		},
+		{
+			name: "left not less than right",
+			nodes: []treestorage.NestedSetsNode{
+				{Name: "Директор", Left: 0, Right: 3},
+				{Name: "Совет лицея", Left: 2, Right: 1},
+			},
+			want: []treestorage.IssueKind{treestorage.IssueInvalidInterval, treestorage.IssueGap},
+		},
+		{
+			name: "duplicate boundary",
+			nodes: []treestorage.NestedSetsNode{
+				{Name: "Директор", Left: 0, Right: 5},
+				{Name: "Совет лицея", Left: 1, Right: 2},
+				{Name: "Ученики", Left: 2, Right: 4},
+			},
+			want: []treestorage.IssueKind{treestorage.IssueDuplicateBoundary, treestorage.IssueMissingBoundary,
+				treestorage.IssueOverlap, treestorage.IssueGap},
+		},
+		{
+			name: "overlapping intervals",
+			nodes: []treestorage.NestedSetsNode{
+				{Name: "Директор", Left: 0, Right: 2},
+				{Name: "Совет лицея", Left: 1, Right: 3},
+			},
+			want: []treestorage.IssueKind{treestorage.IssueOverlap, treestorage.IssueGap},
+		},
+		{
+			name: "gap among children",
+			nodes: []treestorage.NestedSetsNode{
+				{Name: "Директор", Left: 0, Right: 5},
+				{Name: "Совет лицея", Left: 2, Right: 3},
+			},
+			want: []treestorage.IssueKind{treestorage.IssueMissingBoundary, treestorage.IssueGap, treestorage.IssueGap},
+		},
+		{
+			name: "broken root ordering",
+			nodes: []treestorage.NestedSetsNode{
+				{Name: "Директор", Left: 0, Right: 1},
+				{Name: "Директор колледжа", Left: 3, Right: 4},
+			},
+			want: []treestorage.IssueKind{treestorage.IssueMissingBoundary, treestorage.IssueRootOrder},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			report := treestorage.VerifyNodes(tt.nodes)
+			var got []treestorage.IssueKind
+			for _, issue := range report.Issues {
+				got = append(got, issue.Kind)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, len(tt.want) == 0, report.Valid)
+			assert.Equal(t, len(tt.nodes), report.NodeCount)
+		})
+	}
+}
+
+func TestNestedSetsStorage_Verify(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	report, err := s.Verify()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid, report.Issues)
+	assert.Equal(t, treestorage.DefaultTree, report.Tree)
+
+	db, err := sql.Open(dbDriver, dbConnectionString)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+	_, err = db.Exec(`UPDATE nodes SET node_right = node_right + 1 WHERE name = 'Ученики';`)
+	assert.NoError(t, err)
+
+	report, err = s.Verify()
+	assert.NoError(t, err)
+	assert.False(t, report.Valid)
+	assert.NotEmpty(t, report.Issues)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_Rebuild(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	db, err := sql.Open(dbDriver, dbConnectionString)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer db.Close()
+
+	_, err = db.Exec(`UPDATE nodes SET node_right = node_right + 1 WHERE name = 'Ученики';`)
+	assert.NoError(t, err)
+	assert.NoError(t, s.Rebuild())
+	assert.ElementsMatch(t, createTestNodes(), wholeTree(s))
+
+	// the parent ids follow the changes of the tree
+	assert.NoError(t, s.AddNode("Психолог", "Служба сопровождения", treestorage.Position{}))
+	assert.NoError(t, s.MoveNode("Совет лицея", "Заместитель директора по ВР", treestorage.Position{Kind: treestorage.PositionFirst}))
+	assert.NoError(t, s.MoveSubtree("Служба сопровождения", "Директор", treestorage.Position{}))
+	_, err = s.RemoveNode("Заместитель директора по ВР", treestorage.RemovePromote, "")
+	assert.NoError(t, err)
+	want := wholeTree(s)
+
+	_, err = db.Exec(`UPDATE nodes SET node_left = node_left + 100, node_right = node_right + 100 WHERE name = 'Психолог';`)
+	assert.NoError(t, err)
+	report, _ := s.Verify()
+	assert.False(t, report.Valid)
+	assert.NoError(t, s.Rebuild())
+	assert.ElementsMatch(t, want, wholeTree(s))
+	report, _ = s.Verify()
+	assert.True(t, report.Valid, report.Issues)
+
+	// a parent cycle can not be rebuilt
+	_, err = db.Exec(`UPDATE nodes SET parent_id = (SELECT id FROM nodes WHERE name = 'Психолог') WHERE name = 'Директор';`)
+	assert.NoError(t, err)
+	err = s.Rebuild()
+	assert.True(t, errors.Is(err, treestorage.ErrBrokenParents), err)
+	assert.ElementsMatch(t, want, wholeTree(s))
+
+	clearTestDataFromDb()
+}
+
+func TestNumberImport(t *testing.T) {
+	tests := []struct {
+		name    string
+		nodes   []treestorage.ImportNode
+		want    []treestorage.NestedSetsNode
+		wantErr error
+	}{
+		{
+			name:  "empty import",
+			nodes: []treestorage.ImportNode{},
+			want:  nil,
+		},
+		{
+			name: "forest with children before parents",
+			nodes: []treestorage.ImportNode{
+				{Name: "Ученики", Parent: "Совет лицея"},
+				{Name: "Директор"},
+				{Name: "Совет лицея", Parent: "Директор"},
+				{Name: "Бухгалтерия", Parent: "Директор"},
+				{Name: "Директор колледжа"},
+			},
+			want: []treestorage.NestedSetsNode{
+				{Name: "Директор", Left: 0, Right: 7},
+				{Name: "Совет лицея", Left: 1, Right: 4},
+				{Name: "Ученики", Left: 2, Right: 3},
+				{Name: "Бухгалтерия", Left: 5, Right: 6},
+				{Name: "Директор колледжа", Left: 8, Right: 9},
+			},
+		},
+		{
+			name: "duplicate",
+			nodes: []treestorage.ImportNode{
+				{Name: "Директор"},
+				{Name: "Директор"},
+			},
+			wantErr: treestorage.ErrInvalidArgument,
+		},
+		{
+			name: "orphan",
+			nodes: []treestorage.ImportNode{
+				{Name: "Директор"},
+				{Name: "Ученики", Parent: "Совет лицея"},
+			},
+			wantErr: treestorage.ErrParentNotFound,
+		},
+		{
+			name: "cycle",
+			nodes: []treestorage.ImportNode{
+				{Name: "Директор"},
+				{Name: "Совет лицея", Parent: "Ученики"},
+				{Name: "Ученики", Parent: "Совет лицея"},
+			},
+			wantErr: treestorage.ErrInvalidArgument,
+		},
+		{
+			name: "own parent",
+			nodes: []treestorage.ImportNode{
+				{Name: "Директор", Parent: "Директор"},
+			},
+			wantErr: treestorage.ErrInvalidArgument,
+		},
+		{
+			name: "invalid name",
+			nodes: []treestorage.ImportNode{
+				{Name: "id:5"},
+			},
+			wantErr: treestorage.ErrInvalidName,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := treestorage.NumberImport(tt.nodes)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+			assert.True(t, treestorage.VerifyNodes(got).Valid)
+		})
+	}
+}
+
+func TestParseImportCSV(t *testing.T) {
+	data := "name,parent,head\n" +
+		"Директор,,Петров\n" +
+		"\"Благотворительный фонд \"\"Развитие школы\"\"\",Директор,\n"
+	nodes, err := treestorage.ParseImportCSV(strings.NewReader(data))
+	assert.NoError(t, err)
+	assert.Equal(t, []treestorage.ImportNode{
+		{Name: "Директор", Attributes: treestorage.Attributes{"head": "Петров"}},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Parent: "Директор"},
+	}, nodes)
+
+	_, err = treestorage.ParseImportCSV(strings.NewReader("name,head\nДиректор,Петров\n"))
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidArgument), err)
+}
+
+func TestNestedSetsStorage_Import(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	// the whole tree survives the round trip through the nested JSON
+	nodes, err := s.GetTree("", treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	result, err := s.Import(treestorage.FlattenTree(nodes), treestorage.ImportReplace)
+	assert.NoError(t, err)
+	assert.Equal(t, treestorage.ImportResult{Created: len(createTestNodes())}, result)
+	assert.ElementsMatch(t, createTestNodes(), wholeTree(s))
+	report, _ := s.Verify()
+	assert.True(t, report.Valid, report.Issues)
+
+	// merge appends the new nodes to the existing parents and updates the existing nodes
+	result, err = s.Import([]treestorage.ImportNode{
+		{Name: "Психолог", Parent: "Служба сопровождения"},
+		{Name: "Логопед", Parent: "Служба сопровождения"},
+		{Name: "Бухгалтерия", Parent: "Директор", Attributes: treestorage.Attributes{"room": "101"}},
+		{Name: "Кассир", Parent: "Бухгалтерия"},
+		{Name: "Директор колледжа"},
+	}, treestorage.ImportMerge)
+	assert.NoError(t, err)
+	assert.Equal(t, treestorage.ImportResult{Created: 4, Updated: 1}, result)
+	report, _ = s.Verify()
+	assert.True(t, report.Valid, report.Issues)
+	children, _ := s.GetChildren("Служба сопровождения", 1, treestorage.ReadOptions{})
+	assert.Equal(t, []string{"Психолог", "Логопед"}, childNames(children))
+	attrs, _ := s.GetAttributes("Бухгалтерия")
+	assert.Equal(t, treestorage.Attributes{"room": "101"}, attrs)
+	path, _ := s.GetParents("Кассир", treestorage.ReadOptions{})
+	assert.Equal(t, []string{"Директор", "Бухгалтерия"}, pathNames(path))
+	assert.NoError(t, s.Rebuild())
+	report, _ = s.Verify()
+	assert.True(t, report.Valid, report.Issues)
+
+	// the parents are accepted by id
+	path, _ = s.GetParents("Психолог", treestorage.ReadOptions{})
+	result, err = s.Import([]treestorage.ImportNode{
+		{Name: "Дефектолог", Parent: treestorage.NodeRef(path[len(path)-1].ID)},
+	}, treestorage.ImportMerge)
+	assert.NoError(t, err)
+	assert.Equal(t, treestorage.ImportResult{Created: 1}, result)
+	children, _ = s.GetChildren("Служба сопровождения", 1, treestorage.ReadOptions{})
+	assert.Equal(t, []string{"Психолог", "Логопед", "Дефектолог"}, childNames(children))
+
+	// a failed import changes nothing
+	want := wholeTree(s)
+	_, err = s.Import([]treestorage.ImportNode{{Name: "Бухгалтерия", Parent: "Совет лицея"}}, treestorage.ImportMerge)
+	assert.True(t, errors.Is(err, treestorage.ErrNodeExists), err)
+	_, err = s.Import([]treestorage.ImportNode{{Name: "Учитель", Parent: "Кафедра"}}, treestorage.ImportMerge)
+	assert.True(t, errors.Is(err, treestorage.ErrParentNotFound), err)
+	_, err = s.Import([]treestorage.ImportNode{{Name: "Учитель", Parent: "Кафедра"}}, treestorage.ImportReplace)
+	assert.True(t, errors.Is(err, treestorage.ErrParentNotFound), err)
+	assert.ElementsMatch(t, want, wholeTree(s))
+
+	_, err = s.Tree("missing").ImportContext(context.Background(), nil, treestorage.ImportMerge)
+	assert.True(t, errors.Is(err, treestorage.ErrTreeNotFound), err)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_ImportPerParent(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	assert.NoError(t, s.CreateTree("Оргструктура", treestorage.UniquePerParent))
+	org := s.WithTree("Оргструктура")
+
+	// the nested names repeat under the different parents
+	result, err := org.Import(treestorage.FlattenTree([]treestorage.TreeNode{
+		{Name: "Директор", Children: []treestorage.TreeNode{
+			{Name: "Филиал 1", Children: []treestorage.TreeNode{
+				{Name: "Бухгалтерия", Children: []treestorage.TreeNode{{Name: "Кассир"}}},
+			}},
+			{Name: "Филиал 2", Children: []treestorage.TreeNode{{Name: "Бухгалтерия"}}},
+		}},
+	}), treestorage.ImportReplace)
+	assert.NoError(t, err)
+	assert.Equal(t, treestorage.ImportResult{Created: 6}, result)
+	report, _ := org.Verify()
+	assert.True(t, report.Valid, report.Issues)
+
+	// the merged nodes are matched by name and parent
+	result, err = org.Import([]treestorage.ImportNode{
+		{Name: "Филиал 3", Parent: "Директор"},
+		{Name: "Бухгалтерия", Parent: "Филиал 3"},
+		{Name: "Бухгалтерия", Parent: "Филиал 2", Attributes: treestorage.Attributes{"room": "202"}},
+	}, treestorage.ImportMerge)
+	assert.NoError(t, err)
+	assert.Equal(t, treestorage.ImportResult{Created: 2, Updated: 1}, result)
+	children, _ := org.GetChildren("Филиал 3", 1, treestorage.ReadOptions{})
+	assert.Equal(t, []string{"Бухгалтерия"}, childNames(children))
+	children, _ = org.GetChildren("Филиал 2", 1, treestorage.ReadOptions{WithAttributes: true})
+	assert.Equal(t, []string{"Бухгалтерия"}, childNames(children))
+	assert.Equal(t, treestorage.Attributes{"room": "202"}, children[0].Attributes)
+	children, _ = org.GetChildren("Филиал 1", 0, treestorage.ReadOptions{})
+	assert.Equal(t, []string{"Бухгалтерия", "Кассир"}, childNames(children))
+	report, _ = org.Verify()
+	assert.True(t, report.Valid, report.Issues)
+
+	want := wholeTree(org)
+	_, err = org.Import([]treestorage.ImportNode{
+		{Name: "Касса", Parent: "Филиал 3"},
+		{Name: "Касса", Parent: "Филиал 3"},
+	}, treestorage.ImportMerge)
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidArgument), err)
+	_, err = org.Import([]treestorage.ImportNode{{Name: "Кассир", Parent: "Бухгалтерия"}}, treestorage.ImportMerge)
+	assert.True(t, errors.Is(err, treestorage.ErrAmbiguousName), err)
+	assert.ElementsMatch(t, want, wholeTree(org))
+
+	clearTestDataFromDb()
+}
+
+func TestWriteExport(t *testing.T) {
+	nodes := []treestorage.NestedSetsNode{
+		{ID: 3, Name: "Совет лицея", Left: 1, Right: 4},
+		{ID: 1, Name: "Директор", Left: 0, Right: 7},
+		{ID: 4, Name: "Благотворительный фонд \"Развитие школы\"", Left: 2, Right: 3},
+		{ID: 2, Name: "Бухгалтерия", Left: 5, Right: 6},
+	}
+
+	tests := []struct {
+		name    string
+		format  treestorage.ExportFormat
+		want    string
+		wantErr error
+	}{
+		{
+			name:   "dot",
+			format: treestorage.ExportDOT,
+			want: "digraph tree {\n" +
+				"\tn1 [label=\"Директор\"];\n" +
+				"\tn3 [label=\"Совет лицея\"];\n" +
+				"\tn1 -> n3;\n" +
+				"\tn4 [label=\"Благотворительный фонд \\\"Развитие школы\\\"\"];\n" +
+				"\tn3 -> n4;\n" +
+				"\tn2 [label=\"Бухгалтерия\"];\n" +
+				"\tn1 -> n2;\n" +
+				"}\n",
+		},
+		{
+			name:   "mermaid",
+			format: treestorage.ExportMermaid,
+			want: "flowchart TD\n" +
+				"\tn1[\"Директор\"]\n" +
+				"\tn3[\"Совет лицея\"]\n" +
+				"\tn1 --> n3\n" +
+				"\tn4[\"Благотворительный фонд #quot;Развитие школы#quot;\"]\n" +
+				"\tn3 --> n4\n" +
+				"\tn2[\"Бухгалтерия\"]\n" +
+				"\tn1 --> n2\n",
+		},
+		{
+			name:   "csv",
+			format: treestorage.ExportCSV,
+			want: "name,parent,depth,position\n" +
+				"Директор,,0,0\n" +
+				"Совет лицея,Директор,1,0\n" +
+				"\"Благотворительный фонд \"\"Развитие школы\"\"\",Совет лицея,2,0\n" +
+				"Бухгалтерия,Директор,1,1\n",
+		},
+		{
+			name:    "unknown format",
+			format:  "svg",
+			wantErr: treestorage.ErrInvalidArgument,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b strings.Builder
+			err := treestorage.WriteExport(&b, tt.format, nodes)
+			if tt.wantErr != nil {
+				assert.True(t, errors.Is(err, tt.wantErr), err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, b.String())
+		})
+	}
+}
+
+func TestNestedSetsStorage_Export(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	// the CSV export of a subtree imports back as the same subtree
+	var b strings.Builder
+	assert.NoError(t, s.Export(&b, "Совет лицея", treestorage.ExportCSV))
+	assert.True(t, strings.HasPrefix(b.String(), "name,parent,depth,position\nСовет лицея,,0,0\n"), b.String())
+	nodes, err := treestorage.ParseImportCSV(strings.NewReader(b.String()))
+	assert.NoError(t, err)
+	numbered, err := treestorage.NumberImport(nodes)
+	assert.NoError(t, err)
+	subtree, _ := s.GetSubtree("Совет лицея", treestorage.ReadOptions{})
+	assert.Equal(t, len(subtree), len(numbered))
+
+	b.Reset()
+	assert.NoError(t, s.Export(&b, "", treestorage.ExportMermaid))
+	assert.Equal(t, len(createTestNodes())*2, strings.Count(b.String(), "\n"))
+
+	err = s.Export(&b, "Кафедра", treestorage.ExportDOT)
+	assert.True(t, errors.Is(err, treestorage.ErrNodeNotFound), err)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_Batch(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	results, err := s.Batch([]treestorage.BatchOp{
+		{Op: treestorage.BatchAdd, Name: "Психолог", Parent: "Служба сопровождения"},
+		{Op: treestorage.BatchMove, Name: "Служба сопровождения", Parent: "Директор", Subtree: true,
+			Position: treestorage.Position{Kind: treestorage.PositionFirst}},
+		{Op: treestorage.BatchRename, Name: "Психолог", NewName: "Педагог-психолог"},
+		{Op: treestorage.BatchRemove, Name: "Совет лицея", Mode: treestorage.RemoveCascade},
+		{Op: treestorage.BatchRoot, Name: "Директор колледжа"},
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []treestorage.BatchResult{
+		{Op: treestorage.BatchAdd, Name: "Психолог"},
+		{Op: treestorage.BatchMove, Name: "Служба сопровождения"},
+		{Op: treestorage.BatchRename, Name: "Психолог"},
+		{Op: treestorage.BatchRemove, Name: "Совет лицея", Removed: []string{"Совет лицея",
+			"Благотворительный фонд \"Развитие школы\"", "Ученическое самоуправление", "Ученики"}},
+		{Op: treestorage.BatchRoot, Name: "Директор колледжа"},
+	}, results)
+	path, _ := s.GetParents("Педагог-психолог", treestorage.ReadOptions{})
+	assert.Equal(t, []string{"Директор", "Служба сопровождения"}, pathNames(path))
+	report, _ := s.Verify()
+	assert.True(t, report.Valid, report.Issues)
+
+	// the first failure rolls back the whole batch
+	want := wholeTree(s)
+	results, err = s.Batch([]treestorage.BatchOp{
+		{Op: treestorage.BatchAdd, Name: "Логопед", Parent: "Служба сопровождения"},
+		{Op: treestorage.BatchMove, Name: "Директор", Parent: "Служба сопровождения", Subtree: true},
+		{Op: treestorage.BatchRename, Name: "Логопед", NewName: "Дефектолог"},
+	})
+	assert.True(t, errors.Is(err, treestorage.ErrMoveIntoSubtree), err)
+	assert.Len(t, results, 2)
+	assert.Empty(t, results[0].Error)
+	assert.NotEmpty(t, results[1].Error)
+	assert.ElementsMatch(t, want, wholeTree(s))
+
+	results, err = s.Batch([]treestorage.BatchOp{{Op: "sort", Name: "Директор"}})
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidArgument), err)
+	assert.Len(t, results, 1)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_ConcurrentWrites(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	const workers = 8
+	const rounds = 10
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*rounds*3)
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < rounds; i++ {
+				root := fmt.Sprintf("Корень %d-%d", w, i)
+				errs <- s.AddRoot(root)
+				errs <- s.AddNode(fmt.Sprintf("Узел %d-%d", w, i), "Директор", treestorage.Position{})
+				errs <- s.MoveSubtree(root, "Совет лицея", treestorage.Position{Kind: treestorage.PositionFirst})
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.NoError(t, err)
+	}
+	report, err := s.Verify()
+	assert.NoError(t, err)
+	assert.True(t, report.Valid, report.Issues)
+	assert.Equal(t, len(createTestNodes())+2*workers*rounds, report.NodeCount)
+	children, _ := s.GetChildren("Совет лицея", 1, treestorage.ReadOptions{})
+	assert.Len(t, children, 2+workers*rounds)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_TreeVersion(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	version, err := s.TreeVersion()
+	assert.NoError(t, err)
+
+	// every write bumps the version once
+	check := &treestorage.VersionCheck{Expected: version}
+	ctx := treestorage.WithVersionCheck(context.Background(), check)
+	assert.NoError(t, s.AddNodeContext(ctx, "Психолог", "Служба сопровождения", treestorage.Position{}))
+	assert.Equal(t, version+1, check.Committed)
+	got, _ := s.TreeVersion()
+	assert.Equal(t, version+1, got)
+
+	// a stale version is rejected and changes nothing
+	want := wholeTree(s)
+	err = s.RenameNodeContext(ctx, "Психолог", "Педагог-психолог")
+	assert.True(t, errors.Is(err, treestorage.ErrVersionMismatch), err)
+	assert.ElementsMatch(t, want, wholeTree(s))
+	got, _ = s.TreeVersion()
+	assert.Equal(t, version+1, got)
+
+	// a failed write keeps the version
+	_, err = s.RemoveNode("Кафедра", treestorage.RemovePromote, "")
+	assert.True(t, errors.Is(err, treestorage.ErrNodeNotFound), err)
+	got, _ = s.TreeVersion()
+	assert.Equal(t, version+1, got)
+
+	check.Expected = -1
+	assert.NoError(t, s.RenameNodeContext(ctx, "Психолог", "Педагог-психолог"))
+	assert.Equal(t, version+2, check.Committed)
+
+	_, err = s.Tree("missing").TreeVersionContext(context.Background())
+	assert.True(t, errors.Is(err, treestorage.ErrTreeNotFound), err)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_Audit(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	start := time.Now().Add(-time.Minute)
+	ctx := treestorage.WithAuditInfo(context.Background(), treestorage.AuditInfo{Actor: "editor", RequestID: "req-1"})
+	assert.NoError(t, s.AddNodeContext(ctx, "Психолог", "Служба сопровождения", treestorage.Position{}))
+	assert.NoError(t, s.MoveNodeContext(ctx, "Психолог", "Заместитель директора по ВР", treestorage.Position{Kind: treestorage.PositionFirst}))
+	assert.NoError(t, s.RenameNodeContext(ctx, "Психолог", "Педагог-психолог"))
+	assert.NoError(t, s.PatchAttributesContext(ctx, "Педагог-психолог", treestorage.Attributes{"room": "214"}))
+	_, err := s.RemoveNodeContext(ctx, "Педагог-психолог", treestorage.RemovePromote, "")
+	assert.NoError(t, err)
+
+	// a failed change is not recorded
+	assert.Error(t, s.AddNodeContext(ctx, "Логопед", "Кафедра", treestorage.Position{}))
+
+	records, err := s.GetAudit(treestorage.AuditFilter{})
+	assert.NoError(t, err)
+	operations := make([]string, len(records))
+	for i, record := range records {
+		operations[i] = record.Operation
+	}
+	assert.Equal(t, []string{treestorage.OpRemoveNode, treestorage.OpUpdateAttrs, treestorage.OpRenameNode,
+		treestorage.OpMoveNode, treestorage.OpAddNode}, operations)
+
+	added := records[4]
+	assert.Equal(t, "Психолог", added.Node)
+	assert.Equal(t, "editor", added.Actor)
+	assert.Equal(t, "req-1", added.RequestID)
+	assert.Equal(t, "Служба сопровождения", added.Arguments["parent"])
+	assert.Nil(t, added.Before)
+	assert.Equal(t, "Служба сопровождения", added.After.Parent)
+
+	moved := records[3]
+	assert.Equal(t, *added.After, *moved.Before)
+	assert.Equal(t, "Заместитель директора по ВР", moved.After.Parent)
+	assert.Nil(t, records[0].After)
+
+	// the node reference by id finds the records made before the rename
+	records, err = s.GetAudit(treestorage.AuditFilter{Node: treestorage.NodeRef(added.NodeID)})
+	assert.NoError(t, err)
+	assert.Len(t, records, 5)
+	records, _ = s.GetAudit(treestorage.AuditFilter{Operation: treestorage.OpRenameNode})
+	assert.Len(t, records, 1)
+	assert.Equal(t, "Педагог-психолог", records[0].Arguments["new_name"])
+	records, _ = s.GetAudit(treestorage.AuditFilter{From: start, Limit: 2})
+	assert.Len(t, records, 2)
+	records, _ = s.GetAudit(treestorage.AuditFilter{To: start})
+	assert.Empty(t, records)
+
+	_, err = s.Tree("missing").GetAuditContext(context.Background(), treestorage.AuditFilter{})
+	assert.True(t, errors.Is(err, treestorage.ErrTreeNotFound), err)
+
+	// the records of a deleted tree are kept with its name
+	assert.NoError(t, s.CreateTreeContext(ctx, "Каталог", treestorage.UniquePerParent))
+	assert.NoError(t, s.WithTree("Каталог").AddRootContext(ctx, "Товары"))
+	assert.NoError(t, s.DeleteTreeContext(ctx, "Каталог"))
+	records, err = s.Tree("Каталог").GetAuditContext(context.Background(), treestorage.AuditFilter{})
+	assert.NoError(t, err)
+	operations = make([]string, len(records))
+	for i, record := range records {
+		operations[i] = record.Operation
+		assert.Equal(t, "editor", record.Actor)
+		assert.Equal(t, "req-1", record.RequestID)
+	}
+	assert.Equal(t, []string{treestorage.OpDeleteTree, treestorage.OpAddRoot, treestorage.OpCreateTree}, operations)
+	assert.Equal(t, string(treestorage.UniquePerParent), records[2].Arguments["uniqueness"])
+	records, _ = s.GetAudit(treestorage.AuditFilter{})
+	assert.Len(t, records, 5)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_AuditPerParent(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	assert.NoError(t, s.CreateTree("Оргструктура", treestorage.UniquePerParent))
+	org := s.WithTree("Оргструктура")
+	assert.NoError(t, org.AddRoot("Директор"))
+	assert.NoError(t, org.AddNode("Филиал 1", "Директор", treestorage.Position{}))
+	assert.NoError(t, org.AddNode("Бухгалтерия", "Директор", treestorage.Position{}))
+	// the added names are taken by the nodes added before
+	assert.NoError(t, org.AddNode("Бухгалтерия", "Филиал 1", treestorage.Position{}))
+	assert.NoError(t, org.AddRoot("Филиал 1"))
+	records, err := org.GetAudit(treestorage.AuditFilter{Limit: 1})
+	assert.NoError(t, err)
+	root := records[0]
+	assert.Equal(t, treestorage.OpAddRoot, root.Operation)
+	assert.Equal(t, "", root.After.Parent)
+	// the added name is ambiguous in the tree
+	assert.NoError(t, org.AddNode("Бухгалтерия", treestorage.NodeRef(root.NodeID), treestorage.Position{}))
+
+	records, err = org.GetAudit(treestorage.AuditFilter{})
+	assert.NoError(t, err)
+	assert.Len(t, records, 6)
+	ids := map[int]bool{}
+	for _, record := range records {
+		assert.NotZero(t, record.NodeID)
+		assert.Nil(t, record.Before)
+		assert.NotNil(t, record.After)
+		ids[record.NodeID] = true
+	}
+	assert.Len(t, ids, 6)
+
+	assert.Equal(t, root.NodeID, records[1].NodeID)
+	nested := records[2]
+	assert.Equal(t, treestorage.OpAddNode, nested.Operation)
+	assert.Equal(t, "Бухгалтерия", nested.Node)
+	assert.Equal(t, "Филиал 1", nested.After.Parent)
+	assert.NotEqual(t, records[3].NodeID, nested.NodeID)
+	assert.Equal(t, "Бухгалтерия", records[0].Node)
+	assert.Equal(t, "Филиал 1", records[0].After.Parent)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_AsOf(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	want := wholeTree(s)
+	parents, _ := s.GetParents("Служба сопровождения", treestorage.ReadOptions{})
+	children, _ := s.GetChildren("Заместитель директора по ВР", 0, treestorage.ReadOptions{})
+	descendants, _ := s.GetChildren("Директор", 0, treestorage.ReadOptions{})
+	time.Sleep(10 * time.Millisecond)
+	past := treestorage.ReadOptions{AsOf: time.Now()}
+	time.Sleep(10 * time.Millisecond)
+
+	assert.NoError(t, s.AddNode("Психолог", "Служба сопровождения", treestorage.Position{}))
+	assert.NoError(t, s.MoveSubtree("Служба сопровождения", "Директор", treestorage.Position{}))
+	assert.NoError(t, s.PatchAttributes("Директор", treestorage.Attributes{"room": "101"}))
+
+	nodes, err := s.GetWholeTree(past)
+	assert.NoError(t, err)
+	for i := range nodes {
+		nodes[i].ID = 0
+	}
+	assert.ElementsMatch(t, want, nodes)
+	got, err := s.GetParents("Служба сопровождения", past)
+	assert.NoError(t, err)
+	assert.Equal(t, parents, got)
+	gotChildren, err := s.GetChildren("Заместитель директора по ВР", 0, past)
+	assert.NoError(t, err)
+	assert.Equal(t, children, gotChildren)
+	// the walk goes down the past parents more than one level
+	gotChildren, err = s.GetChildren("Директор", 0, past)
+	assert.NoError(t, err)
+	assert.Equal(t, descendants, gotChildren)
+	assert.Equal(t, "Ученики", gotChildren[5].Name)
+	assert.Equal(t, 3, gotChildren[5].Depth)
+	gotChildren, err = s.GetChildren("Директор", 2, past)
+	assert.NoError(t, err)
+	assert.Len(t, gotChildren, 16)
+
+	// the node added later did not exist then
+	_, err = s.GetParents("Психолог", past)
+	assert.True(t, errors.Is(err, treestorage.ErrNodeNotFound), err)
+	got, err = s.GetParents("Психолог", treestorage.ReadOptions{AsOf: time.Now()})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Директор", "Служба сопровождения"}, pathNames(got))
+
+	_, err = s.Tree("missing").GetWholeTreeContext(context.Background(), past)
+	assert.True(t, errors.Is(err, treestorage.ErrTreeNotFound), err)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_HistoryRows(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	db, err := sql.Open(dbDriver, dbConnectionString)
+	assert.NoError(t, err)
+	defer db.Close()
+	historyRows := func() int {
+		var count int
+		assert.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM nodes_history;`).Scan(&count))
+		return count
+	}
+
+	// the shifted left and right numbers of the other nodes are not versioned
+	before := historyRows()
+	assert.NoError(t, s.AddNode("Психолог", "Служба сопровождения", treestorage.Position{}))
+	assert.Equal(t, before+1, historyRows())
+	assert.NoError(t, s.RenameNode("Психолог", "Педагог-психолог"))
+	assert.Equal(t, before+2, historyRows())
+	// the last child leaves the ranks of its siblings as they are
+	assert.NoError(t, s.AddNode("Логопед", "Директор", treestorage.Position{Kind: treestorage.PositionLast}))
+	assert.Equal(t, before+3, historyRows())
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_GetTree(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	roots, err := s.GetTree("", treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, roots, 1)
+	assert.Equal(t, "Директор", roots[0].Name)
+	assert.Equal(t, len(createTestNodes()), countTreeNodes(roots))
+
+	subtree, err := s.GetTree("Совет лицея", treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	assert.Len(t, subtree, 1)
+	assert.Equal(t, "Совет лицея", subtree[0].Name)
+	children, _ := s.GetChildren("Совет лицея", 1, treestorage.ReadOptions{})
+	assert.Len(t, subtree[0].Children, len(children))
+	for i, child := range children {
+		assert.Equal(t, child.Name, subtree[0].Children[i].Name)
+	}
+
+	_, err = s.GetTree("Заместитель директора", treestorage.ReadOptions{})
+	assert.True(t, errors.Is(err, treestorage.ErrNodeNotFound), err)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_RemoveNode(t *testing.T) {
+	refillTestData()
+	defaultNodes := createTestNodes()
+
+	type args struct {
+		name string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
+	}{
+		{
+			name:    "removing not existing nodes",
+			args:    args{"Психолог"},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrNodeNotFound,
+		},
+		{
+			name:    "removing invalid name nodes",
+			args:    args{""},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrInvalidName,
+		},
+		{
+			name: "removeNodeCase1",
+			args: args{"Служба сопровождения"},
+			want: removeNodeCase1(),
+		},
+		{
+			name: "removeNodeCase2",
+			args: args{"Совет лицея"},
+			want: removeNodeCase2(),
+		},
+		{
+			name: "removeNodeCase3",
+			args: args{"Директор"},
+			want: removeNodeCase3(),
+		},
+	}
+
+	s := newTestStorage()
+	defer s.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := s.RemoveNode(tt.args.name, treestorage.RemovePromote, "")
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			got := wholeTree(s)
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_RemoveNodeModes(t *testing.T) {
+	defaultNodes := createTestNodes()
+
+	type args struct {
+		name   string
+		mode   treestorage.RemoveMode
+		target string
+	}
+	tests := []struct {
+		name        string
+		args        args
+		want        []treestorage.NestedSetsNode
+		wantRemoved []string
+		wantErr     error
+	}{
+		{
+			name:        "removing with unknown mode",
+			args:        args{"Совет лицея", "drop", ""},
+			want:        defaultNodes,
+			wantRemoved: []string{},
+			wantErr:     treestorage.ErrInvalidArgument,
+		},
+		{
+			name:        "cascade removing not existing node",
+			args:        args{"Психолог", treestorage.RemoveCascade, ""},
+			want:        defaultNodes,
+			wantRemoved: []string{},
+			wantErr:     treestorage.ErrNodeNotFound,
+		},
+		{
+			name:        "reassigning children to not existing node",
+			args:        args{"Совет лицея", treestorage.RemoveReassign, "Психолог"},
+			want:        defaultNodes,
+			wantRemoved: []string{},
+			wantErr:     treestorage.ErrParentNotFound,
+		},
+		{
+			name:        "reassigning children to the node descendant",
+			args:        args{"Совет лицея", treestorage.RemoveReassign, "Ученики"},
+			want:        defaultNodes,
+			wantRemoved: []string{},
+			wantErr:     treestorage.ErrMoveIntoSubtree,
+		},
+		{
+			name: "cascade removing",
+			args: args{"Совет лицея", treestorage.RemoveCascade, ""},
+			want: removeSubtreeCase(),
+			wantRemoved: []string{
+				"Совет лицея",
+				"Благотворительный фонд \"Развитие школы\"",
+				"Ученическое самоуправление",
+				"Ученики",
+			},
+		},
+		{
+			name:        "reassigning children",
+			args:        args{"Совет лицея", treestorage.RemoveReassign, "Заместитель директора по АХЧ"},
+			want:        reassignChildrenCase(),
+			wantRemoved: []string{"Совет лицея"},
+		},
+	}
+
+	s := newTestStorage()
+	defer s.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refillTestData()
+			removed, err := s.RemoveNode(tt.args.name, tt.args.mode, tt.args.target)
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			assert.Equal(t, tt.wantRemoved, removed)
+			got := wholeTree(s)
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_MoveNode(t *testing.T) {
+	refillTestData()
+	defaultNodes := createTestNodes()
+
+	type args struct {
+		name      string
+		newParent string
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
+	}{
+		{
+			name:    "moving invalid node",
+			args:    args{"", "Заместитель директора по ВР"},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrInvalidName,
+		},
+		{
+			name:    "moving not existing node",
+			args:    args{"Психолог", "Заместитель директора по ВР"},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrNodeNotFound,
+		},
+		{
+			name:    "moving to invalid parent",
+			args:    args{"Заместитель директора по ВР", ""},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrInvalidName,
+		},
+		{
+			name:    "moving to not existing node",
+			args:    args{"Заместитель директора по ВР", "Психолог"},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrParentNotFound,
+		},
+		{
+			name: "not modifying moving",
+			args: args{"Благотворительный фонд \"Развитие школы\"", "Совет лицея"},
+			want: defaultNodes,
+		},
+		{
+			name: "moving node case 1: left direction move to the right parent node",
+			args: args{"Педагогический совет", "Заместитель директора по ВР"},
+			want: moveNodeCase1(),
+		},
+		{
+			name: "moving node case 2: right direction move to the left parent node",
+			args: args{"Совет лицея", "Заместитель директора по ВР"},
+			want: moveNodeCase2(),
+		},
+		{
+			name: "moving node case 3: right direction move to the left parent node",
+			args: args{"Методическое объединение педагогов дополнительного образования", "Методическое объединение классных руководителей"},
+			want: moveNodeCase3(),
+		},
+		{
+			name: "moving node case 4: left direction move to hte right parent node",
+			args: args{"Педагогический совет", "Заместитель директора по ВР"},
+			want: moveNodeCase4(),
+		},
+		{
+			name: "moving node case 5: moving down along branch",
+			args: args{"Ученическое самоуправление", "Ученики"},
+			want: moveNodeCase5(),
+		},
+		{
+			name: "moving node case 6: moving up along branch to the right parent node",
+			args: args{"Ученики", "Совет лицея"},
+			want: moveNodeCase6(),
+		},
+		{
+			name: "moving node case 7: moving up along branch to the left parent node",
+			args: args{"Совет лицея", "Директор"},
+			want: moveNodeCase7(),
+		},
+		{
+			name: "moving node case 8: moving down along branch",
+			args: args{"Совет лицея", "Ученики"},
+			want: moveNodeCase8(),
+		},
+	}
+
+	s := newTestStorage()
+	defer s.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refillTestData()
+			err := s.MoveNode(tt.args.name, tt.args.newParent, treestorage.Position{})
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			got := wholeTree(s)
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_MoveNodePosition(t *testing.T) {
+	defaultNodes := createTestNodes()
+
+	type args struct {
+		name      string
+		newParent string
+		pos       treestorage.Position
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
+	}{
+		{
+			name:    "moving before a sibling of another parent",
+			args:    args{"Педагогический совет", "Заместитель директора по ВР", treestorage.Position{treestorage.PositionBefore, "Бухгалтерия"}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrSiblingNotFound,
+		},
+		{
+			name:    "moving after itself",
+			args:    args{"Бухгалтерия", "Директор", treestorage.Position{treestorage.PositionAfter, "Бухгалтерия"}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrSiblingNotFound,
+		},
+		{
+			name: "moving node to the first child position",
+			args: args{"Педагогический совет", "Заместитель директора по ВР", treestorage.Position{Kind: treestorage.PositionFirst}},
+			want: moveNodePositionCase1(),
+		},
+		{
+			name: "moving node with children after a sibling",
+			args: args{"Совет лицея", "Директор", treestorage.Position{treestorage.PositionAfter, "Бухгалтерия"}},
+			want: moveNodePositionCase2(),
+		},
+		{
+			name: "moving node before a sibling under the same parent",
+			args: args{"Ученическое самоуправление", "Совет лицея", treestorage.Position{treestorage.PositionBefore, "Благотворительный фонд \"Развитие школы\""}},
+			want: moveNodePositionCase3(),
+		},
+		{
+			name: "moving node down along branch to the first child position",
+			args: args{"Совет лицея", "Ученики", treestorage.Position{Kind: treestorage.PositionFirst}},
+			want: moveNodePositionCase4(),
+		},
+	}
+
+	s := newTestStorage()
+	defer s.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refillTestData()
+			err := s.MoveNode(tt.args.name, tt.args.newParent, tt.args.pos)
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			got := wholeTree(s)
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_MoveSubtree(t *testing.T) {
+	defaultNodes := createTestNodes()
+
+	type args struct {
+		name      string
+		newParent string
+		pos       treestorage.Position
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
+	}{
+		{
+			name:    "moving not existing subtree",
+			args:    args{"Психолог", "Заместитель директора по ВР", treestorage.Position{}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrNodeNotFound,
+		},
+		{
+			name:    "moving to not existing node",
+			args:    args{"Совет лицея", "Психолог", treestorage.Position{}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrParentNotFound,
+		},
+		{
+			name:    "moving subtree to itself",
+			args:    args{"Совет лицея", "Совет лицея", treestorage.Position{}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrMoveIntoSubtree,
+		},
+		{
+			name:    "moving subtree to its descendant",
+			args:    args{"Совет лицея", "Ученики", treestorage.Position{}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrMoveIntoSubtree,
+		},
+		{
+			name: "moving subtree case 1: right direction",
+			args: args{"Совет лицея", "Заместитель директора по ВР", treestorage.Position{}},
+			want: moveSubtreeCase1(),
+		},
+		{
+			name:    "moving subtree before a sibling of another parent",
+			args:    args{"Совет лицея", "Заместитель директора по ВР", treestorage.Position{treestorage.PositionBefore, "Бухгалтерия"}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrSiblingNotFound,
+		},
+		{
+			name: "moving subtree case 3: to the first child position",
+			args: args{"Совет лицея", "Заместитель директора по ВР", treestorage.Position{Kind: treestorage.PositionFirst}},
+			want: moveSubtreeCase3(),
 		},
 		{
-			name: "removeNodeCase3",
-			args: args{"Директор"},
-			want: removeNodeCase3(),
+			name: "moving subtree case 2: left direction",
+			args: args{"Заместитель директора по УВР", "Заместитель директора по АХЧ", treestorage.Position{}},
+			want: moveSubtreeCase2(),
 		},
 	}
 
-	s := &treestorage.NestedSetsStorage{
-		DbConnectionString: dbConnectionString,
-		DbDriver:           dbDriver,
-	}
+	s := newTestStorage()
+	defer s.Close()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s.RemoveNode(tt.args.name)
-			got, _ := s.GetWholeTree()
+			refillTestData()
+			err := s.MoveSubtree(tt.args.name, tt.args.newParent, tt.args.pos)
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			got := wholeTree(s)
 			assert.ElementsMatch(t, tt.want, got)
 		})
 	}
@@ -283,103 +1770,146 @@ func TestNestedSetsStorage_RemoveNode(t *testing.T) {
 	clearTestDataFromDb()
 }
 
-func TestNestedSetsStorage_MoveNode(t *testing.T) {
-	refillTestData()
+func TestNestedSetsStorage_ReorderChildren(t *testing.T) {
 	defaultNodes := createTestNodes()
 
 	type args struct {
-		name      string
-		newParent string
+		parent string
+		names  []string
 	}
 	tests := []struct {
-		name string
-		args args
-		want []treestorage.NestedSetsNode
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
 	}{
 		{
-			name: "moving invalid node",
-			args: args{"", "Заместитель директора по ВР"},
-			want: defaultNodes,
-		},
-		{
-			name: "moving not existing node",
-			args: args{"Психолог", "Заместитель директора по ВР"},
-			want: defaultNodes,
-		},
-		{
-			name: "moving to invalid parent",
-			args: args{"Заместитель директора по ВР", ""},
-			want: defaultNodes,
-		},
-		{
-			name: "moving to not existing node",
-			args: args{"Заместитель директора по ВР", "Психолог"},
-			want: defaultNodes,
+			name:    "reordering children of not existing node",
+			args:    args{"Психолог", []string{}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrParentNotFound,
 		},
 		{
-			name: "not modifying moving",
-			args: args{"Благотворительный фонд \"Развитие школы\"", "Совет лицея"},
-			want: defaultNodes,
+			name:    "reordering with a missing child",
+			args:    args{"Совет лицея", []string{"Ученическое самоуправление"}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrChildrenMismatch,
 		},
 		{
-			name: "moving node case 1: left direction move to the right parent node",
-			args: args{"Педагогический совет", "Заместитель директора по ВР"},
-			want: moveNodeCase1(),
+			name:    "reordering with a not direct child",
+			args:    args{"Совет лицея", []string{"Ученики", "Благотворительный фонд \"Развитие школы\""}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrChildrenMismatch,
 		},
 		{
-			name: "moving node case 2: right direction move to the left parent node",
-			args: args{"Совет лицея", "Заместитель директора по ВР"},
-			want: moveNodeCase2(),
+			name:    "reordering with a duplicated child",
+			args:    args{"Совет лицея", []string{"Ученическое самоуправление", "Ученическое самоуправление", "Благотворительный фонд \"Развитие школы\""}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrChildrenMismatch,
 		},
 		{
-			name: "moving node case 3: right direction move to the left parent node",
-			args: args{"Методическое объединение педагогов дополнительного образования", "Методическое объединение классных руководителей"},
-			want: moveNodeCase3(),
+			name: "reordering leaves",
+			args: args{"Заместитель директора по ВР", []string{
+				"Методическое объединение классных руководителей",
+				"Служба сопровождения",
+				"Методическое объединение педагогов дополнительного образования",
+			}},
+			want: reorderChildrenCase1(),
 		},
 		{
-			name: "moving node case 4: left direction move to hte right parent node",
-			args: args{"Педагогический совет", "Заместитель директора по ВР"},
-			want: moveNodeCase4(),
+			name: "reordering subtrees",
+			args: args{"Совет лицея", []string{"Ученическое самоуправление", "Благотворительный фонд \"Развитие школы\""}},
+			want: reorderChildrenCase2(),
 		},
+	}
+
+	s := newTestStorage()
+	defer s.Close()
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refillTestData()
+			err := s.ReorderChildren(tt.args.parent, tt.args.names)
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			got := wholeTree(s)
+			assert.ElementsMatch(t, tt.want, got)
+		})
+	}
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_SortChildren(t *testing.T) {
+	defaultNodes := createTestNodes()
+
+	type args struct {
+		parent string
+		order  treestorage.SortOrder
+	}
+	tests := []struct {
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
+	}{
 		{
-			name: "moving node case 5: moving down along branch",
-			args: args{"Ученическое самоуправление", "Ученики"},
-			want: moveNodeCase5(),
+			name: "sorting by missing attribute",
+			args: args{"Заместитель директора по ВР", treestorage.SortOrder{Key: "headcount"}},
+			want: sortChildrenCase(),
 		},
 		{
-			name: "moving node case 6: moving up along branch to the right parent node",
-			args: args{"Ученики", "Совет лицея"},
-			want: moveNodeCase6(),
+			name:    "sorting children of not existing node",
+			args:    args{"Психолог", treestorage.SortOrder{}},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrParentNotFound,
 		},
 		{
-			name: "moving node case 7: moving up along branch to the left parent node",
-			args: args{"Совет лицея", "Директор"},
-			want: moveNodeCase7(),
+			name: "sorting by name",
+			args: args{"Заместитель директора по ВР", treestorage.SortOrder{Key: "name"}},
+			want: sortChildrenCase(),
 		},
 		{
-			name: "moving node case 8: moving down along branch",
-			args: args{"Совет лицея", "Ученики"},
-			want: moveNodeCase8(),
+			name: "sorting by name descending",
+			args: args{"Совет лицея", treestorage.SortOrder{Descending: true}},
+			want: reorderChildrenCase2(),
 		},
 	}
 
-	s := &treestorage.NestedSetsStorage{
-		DbConnectionString: dbConnectionString,
-		DbDriver:           dbDriver,
-	}
+	s := newTestStorage()
+	defer s.Close()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			refillTestData()
-			err := s.MoveNode(tt.args.name, tt.args.newParent)
-			if err != nil {
-				log.Println(err)
-			}
-			got, _ := s.GetWholeTree()
+			err := s.SortChildren(tt.args.parent, tt.args.order)
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			got := wholeTree(s)
 			assert.ElementsMatch(t, tt.want, got)
 		})
 	}
 
+	// the numbers are ordered as numbers, the children without the attribute go last
+	refillTestData()
+	assert.NoError(t, s.PatchAttributes("Служба сопровождения", treestorage.Attributes{"rank": 10}))
+	assert.NoError(t, s.PatchAttributes("Методическое объединение педагогов дополнительного образования",
+		treestorage.Attributes{"rank": 9}))
+	assert.NoError(t, s.SortChildren("Заместитель директора по ВР", treestorage.SortOrder{Key: "rank"}))
+	children, err := s.GetChildren("Заместитель директора по ВР", 1, treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"Методическое объединение педагогов дополнительного образования",
+		"Служба сопровождения",
+		"Методическое объединение классных руководителей",
+	}, childNames(children))
+
+	assert.NoError(t, s.SortChildren("Заместитель директора по ВР", treestorage.SortOrder{Key: "rank", Descending: true}))
+	children, _ = s.GetChildren("Заместитель директора по ВР", 1, treestorage.ReadOptions{})
+	assert.Equal(t, []string{
+		"Служба сопровождения",
+		"Методическое объединение педагогов дополнительного образования",
+		"Методическое объединение классных руководителей",
+	}, childNames(children))
+
 	clearTestDataFromDb()
 }
 
@@ -392,19 +1922,22 @@ func TestNestedSetsStorage_RenameNode(t *testing.T) {
 		newName string
 	}
 	tests := []struct {
-		name string
-		args args
-		want []treestorage.NestedSetsNode
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
 	}{
 		{
-			name: "renaming invalid node",
-			args: args{"", "Заместитель директора"},
-			want: defaultNodes,
+			name:    "renaming invalid node",
+			args:    args{"", "Заместитель директора"},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrInvalidName,
 		},
 		{
-			name: "renaming not existing node",
-			args: args{"Психолог", "Заместитель директора"},
-			want: defaultNodes,
+			name:    "renaming not existing node",
+			args:    args{"Психолог", "Заместитель директора"},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrNodeNotFound,
 		},
 		{
 			name: "renaming node",
@@ -413,15 +1946,14 @@ func TestNestedSetsStorage_RenameNode(t *testing.T) {
 		},
 	}
 
-	s := &treestorage.NestedSetsStorage{
-		DbConnectionString: dbConnectionString,
-		DbDriver:           dbDriver,
-	}
+	s := newTestStorage()
+	defer s.Close()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s.RenameNode(tt.args.name, tt.args.newName)
-			got, _ := s.GetWholeTree()
+			err := s.RenameNode(tt.args.name, tt.args.newName)
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			got := wholeTree(s)
 			assert.ElementsMatch(t, tt.want, got)
 		})
 	}
@@ -437,19 +1969,22 @@ func TestNestedSetsStorage_AddRoot(t *testing.T) {
 		name string
 	}
 	tests := []struct {
-		name string
-		args args
-		want []treestorage.NestedSetsNode
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
 	}{
 		{
-			name: "adding an invalid node",
-			args: args{""},
-			want: defaultNodes,
+			name:    "adding an invalid node",
+			args:    args{""},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrInvalidName,
 		},
 		{
-			name: "adding an existing node",
-			args: args{"Заместитель директора по ВР"},
-			want: defaultNodes,
+			name:    "adding an existing node",
+			args:    args{"Заместитель директора по ВР"},
+			want:    defaultNodes,
+			wantErr: treestorage.ErrNodeExists,
 		},
 		{
 			name: "adding a node",
@@ -458,15 +1993,14 @@ func TestNestedSetsStorage_AddRoot(t *testing.T) {
 		},
 	}
 
-	s := &treestorage.NestedSetsStorage{
-		DbConnectionString: dbConnectionString,
-		DbDriver:           dbDriver,
-	}
+	s := newTestStorage()
+	defer s.Close()
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			s.AddRoot(tt.args.name)
-			got, _ := s.GetWholeTree()
+			err := s.AddRoot(tt.args.name)
+			assert.True(t, errors.Is(err, tt.wantErr), err)
+			got := wholeTree(s)
 			assert.ElementsMatch(t, tt.want, got)
 		})
 	}
@@ -474,21 +2008,22 @@ func TestNestedSetsStorage_AddRoot(t *testing.T) {
 	clearTestDataFromDb()
 
 	tests = []struct {
-		name string
-		args args
-		want []treestorage.NestedSetsNode
+		name    string
+		args    args
+		want    []treestorage.NestedSetsNode
+		wantErr error
 	}{
 		{
 			name: "adding to empty tree",
 			args: args{"Директор колледжа"},
-			want: []treestorage.NestedSetsNode{{"Директор колледжа", 0, 1}},
+			want: []treestorage.NestedSetsNode{{Name: "Директор колледжа", Left: 0, Right: 1}},
 		},
 	}
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			s.AddRoot(tt.args.name)
-			got, _ := s.GetWholeTree()
+			got := wholeTree(s)
 			assert.ElementsMatch(t, tt.want, got)
 		})
 	}
@@ -496,6 +2031,306 @@ func TestNestedSetsStorage_AddRoot(t *testing.T) {
 	clearTestDataFromDb()
 }
 
+func TestNestedSetsStorage_Trees(t *testing.T) {
+	refillTestData()
+	defaultNodes := createTestNodes()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	err := s.CreateTree("Каталог", "")
+	assert.NoError(t, err)
+	err = s.CreateTree("Каталог", "")
+	assert.True(t, errors.Is(err, treestorage.ErrTreeExists), err)
+
+	trees, err := s.ListTrees()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"default", "Каталог"}, trees)
+
+	catalog := s.WithTree("Каталог")
+	got, err := catalog.GetWholeTree(treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	assert.Empty(t, got)
+
+	// the node names are unique within a tree only
+	assert.NoError(t, catalog.AddRoot("Директор"))
+	assert.NoError(t, catalog.AddNode("Товары", "Директор", treestorage.Position{}))
+	got = wholeTree(catalog)
+	assert.ElementsMatch(t, []treestorage.NestedSetsNode{{Name: "Директор", Left: 0, Right: 3}, {Name: "Товары", Left: 1, Right: 2}}, got)
+	got = wholeTree(s)
+	assert.ElementsMatch(t, defaultNodes, got)
+
+	parents, err := catalog.GetParents("Товары", treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Директор"}, pathNames(parents))
+	_, err = catalog.GetParents("Заместитель директора по ВР", treestorage.ReadOptions{})
+	assert.True(t, errors.Is(err, treestorage.ErrNodeNotFound), err)
+
+	missing := s.WithTree("Склады")
+	_, err = missing.GetWholeTree(treestorage.ReadOptions{})
+	assert.True(t, errors.Is(err, treestorage.ErrTreeNotFound), err)
+	_, err = missing.GetParents("Директор", treestorage.ReadOptions{})
+	assert.True(t, errors.Is(err, treestorage.ErrTreeNotFound), err)
+	err = missing.AddRoot("Директор")
+	assert.True(t, errors.Is(err, treestorage.ErrTreeNotFound), err)
+
+	err = s.DeleteTree(treestorage.DefaultTree)
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidArgument), err)
+	err = s.DeleteTree("Склады")
+	assert.True(t, errors.Is(err, treestorage.ErrTreeNotFound), err)
+	assert.NoError(t, s.DeleteTree("Каталог"))
+	_, err = catalog.GetWholeTree(treestorage.ReadOptions{})
+	assert.True(t, errors.Is(err, treestorage.ErrTreeNotFound), err)
+
+	got = wholeTree(s)
+	assert.ElementsMatch(t, defaultNodes, got)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_NodeIDs(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	nodes, err := s.GetWholeTree(treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	ids := make(map[string]int, len(nodes))
+	for _, node := range nodes {
+		assert.NotZero(t, node.ID)
+		ids[node.Name] = node.ID
+	}
+	ref := treestorage.NodeRef(ids["Служба сопровождения"])
+
+	parents, err := s.GetParents(ref, treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []treestorage.PathNode{
+		{ID: ids["Директор"], Name: "Директор"},
+		{ID: ids["Заместитель директора по ВР"], Name: "Заместитель директора по ВР"},
+	}, parents)
+	children, err := s.GetChildren("Совет лицея", 1, treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	for _, child := range children {
+		assert.Equal(t, ids[child.Name], child.ID)
+	}
+
+	// the renamed node keeps its id
+	assert.NoError(t, s.RenameNode(ref, "Служба психологического сопровождения"))
+	assert.NoError(t, s.AddNode("Психолог", ref, treestorage.Position{}))
+	parents, _ = s.GetParents("Психолог", treestorage.ReadOptions{})
+	assert.Equal(t, []string{"Директор", "Заместитель директора по ВР", "Служба психологического сопровождения"},
+		pathNames(parents))
+
+	_, err = s.GetParents(treestorage.NodeRef(0), treestorage.ReadOptions{})
+	assert.True(t, errors.Is(err, treestorage.ErrNodeNotFound), err)
+	err = s.AddNode("id:7", "Директор", treestorage.Position{})
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidName), err)
+	err = s.RenameNode("Психолог", ref)
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidName), err)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_NameUniqueness(t *testing.T) {
+	refillTestData()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	err := s.CreateTree("Оргструктура", "everywhere")
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidArgument), err)
+
+	// the names are unique among the siblings
+	assert.NoError(t, s.CreateTree("Оргструктура", treestorage.UniquePerParent))
+	org := s.WithTree("Оргструктура")
+	assert.NoError(t, org.AddRoot("Директор"))
+	assert.NoError(t, org.AddNode("Филиал 1", "Директор", treestorage.Position{}))
+	assert.NoError(t, org.AddNode("Филиал 2", "Директор", treestorage.Position{}))
+	assert.NoError(t, org.AddNode("Бухгалтерия", "Филиал 1", treestorage.Position{}))
+	assert.NoError(t, org.AddNode("Бухгалтерия", "Филиал 2", treestorage.Position{}))
+
+	err = org.AddNode("Бухгалтерия", "Филиал 1", treestorage.Position{})
+	assert.True(t, errors.Is(err, treestorage.ErrNodeExists), err)
+	err = org.RenameNode("Филиал 2", "Филиал 1")
+	assert.True(t, errors.Is(err, treestorage.ErrNodeExists), err)
+	err = org.MoveSubtree("Филиал 2", "Филиал 1", treestorage.Position{})
+	assert.NoError(t, err)
+	// the children of Филиал 2 would take the place of Филиал 2 among the Филиал 1 children
+	_, err = org.RemoveNode("Филиал 2", treestorage.RemovePromote, "")
+	assert.True(t, errors.Is(err, treestorage.ErrNodeExists), err)
+
+	_, err = org.GetParents("Бухгалтерия", treestorage.ReadOptions{})
+	assert.True(t, errors.Is(err, treestorage.ErrAmbiguousName), err)
+	err = org.MoveNode("Бухгалтерия", "Директор", treestorage.Position{})
+	assert.True(t, errors.Is(err, treestorage.ErrAmbiguousName), err)
+
+	var accounting []int
+	nodes, _ := org.GetWholeTree(treestorage.ReadOptions{})
+	for _, node := range nodes {
+		if node.Name == "Бухгалтерия" {
+			accounting = append(accounting, node.ID)
+		}
+	}
+	assert.Len(t, accounting, 2)
+	for _, id := range accounting {
+		parents, err := org.GetParents(treestorage.NodeRef(id), treestorage.ReadOptions{})
+		assert.NoError(t, err)
+		assert.Contains(t, pathNames(parents), "Филиал 1")
+	}
+
+	// the children are expanded by their ids
+	children, err := org.GetChildren("Филиал 1", 1, treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Бухгалтерия", "Филиал 2"}, childNames(children))
+	children, err = org.GetChildren(treestorage.NodeRef(children[1].ID), 1, treestorage.ReadOptions{})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Бухгалтерия"}, childNames(children))
+
+	// the removed node referred by id is reported by its name
+	removed, err := org.RemoveNode(treestorage.NodeRef(children[0].ID), treestorage.RemovePromote, "")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Бухгалтерия"}, removed)
+
+	// the names are not checked at all
+	assert.NoError(t, s.CreateTree("Черновик", treestorage.UniqueNone))
+	draft := s.WithTree("Черновик")
+	assert.NoError(t, draft.AddRoot("Черновик"))
+	assert.NoError(t, draft.AddNode("Черновик", "Черновик", treestorage.Position{}))
+	assert.NoError(t, draft.AddRoot("Черновик"))
+	nodes, _ = draft.GetWholeTree(treestorage.ReadOptions{})
+	assert.Len(t, nodes, 3)
+
+	// the default tree keeps the names unique in the whole tree
+	err = s.AddNode("Ученики", "Директор", treestorage.Position{})
+	assert.True(t, errors.Is(err, treestorage.ErrNodeExists), err)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_Attributes(t *testing.T) {
+	refillTestData()
+	defaultNodes := createTestNodes()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	attrs, err := s.GetAttributes("Служба сопровождения")
+	assert.NoError(t, err)
+	assert.Empty(t, attrs)
+
+	err = s.SetAttributes("Служба сопровождения", treestorage.Attributes{"head": "Иванова", "headcount": 4})
+	assert.NoError(t, err)
+	err = s.PatchAttributes("Служба сопровождения", treestorage.Attributes{"headcount": 5, "head": nil, "room": "214"})
+	assert.NoError(t, err)
+	attrs, err = s.GetAttributes("Служба сопровождения")
+	assert.NoError(t, err)
+	assert.Equal(t, treestorage.Attributes{"headcount": float64(5), "room": "214"}, attrs)
+
+	assert.NoError(t, s.DeleteAttribute("Служба сопровождения", "room"))
+	attrs, _ = s.GetAttributes("Служба сопровождения")
+	assert.Equal(t, treestorage.Attributes{"headcount": float64(5)}, attrs)
+
+	children, err := s.GetChildren("Заместитель директора по ВР", 1, treestorage.ReadOptions{WithAttributes: true})
+	assert.NoError(t, err)
+	for _, child := range children {
+		if child.Name == "Служба сопровождения" {
+			assert.Equal(t, treestorage.Attributes{"headcount": float64(5)}, child.Attributes)
+		} else {
+			assert.Equal(t, treestorage.Attributes{}, child.Attributes)
+		}
+	}
+
+	nodes, _ := s.GetWholeTree(treestorage.ReadOptions{})
+	for _, node := range nodes {
+		assert.Nil(t, node.Attributes)
+	}
+	// the attributes do not affect the tree structure
+	assert.ElementsMatch(t, defaultNodes, wholeTree(s))
+
+	_, err = s.GetAttributes("Заместитель директора")
+	assert.True(t, errors.Is(err, treestorage.ErrNodeNotFound), err)
+	err = s.PatchAttributes("Заместитель директора", treestorage.Attributes{"room": "1"})
+	assert.True(t, errors.Is(err, treestorage.ErrNodeNotFound), err)
+	err = s.DeleteAttribute("Служба сопровождения", "")
+	assert.True(t, errors.Is(err, treestorage.ErrInvalidArgument), err)
+
+	clearTestDataFromDb()
+}
+
+func TestNestedSetsStorage_CanceledContext(t *testing.T) {
+	refillTestData()
+	defaultNodes := createTestNodes()
+
+	s := newTestStorage()
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	_, err := s.GetWholeTreeContext(ctx, treestorage.ReadOptions{})
+	assert.Error(t, err)
+
+	err = s.AddNodeContext(ctx, "Психолог", "Заместитель директора по ВР", treestorage.Position{})
+	assert.Error(t, err)
+
+	got := wholeTree(s)
+	assert.ElementsMatch(t, defaultNodes, got)
+
+	clearTestDataFromDb()
+}
+
+func countTreeNodes(nodes []treestorage.TreeNode) int {
+	count := len(nodes)
+	for _, node := range nodes {
+		count += countTreeNodes(node.Children)
+	}
+	return count
+}
+
+func pathNames(path []treestorage.PathNode) []string {
+	names := make([]string, len(path))
+	for i, node := range path {
+		names[i] = node.Name
+	}
+	return names
+}
+
+func withoutChildIDs(children []treestorage.NestedSetsChild) []treestorage.NestedSetsChild {
+	for i := range children {
+		children[i].ID = 0
+	}
+	return children
+}
+
+func childNames(children []treestorage.NestedSetsChild) []string {
+	names := make([]string, len(children))
+	for i, child := range children {
+		names[i] = child.Name
+	}
+	return names
+}
+
+func newTestStorage() *treestorage.NestedSetsStorage {
+	s, err := treestorage.New(dbDriver, dbConnectionString, treestorage.Options{})
+	if err != nil {
+		log.Fatal(err)
+	}
+	return s
+}
+
+// wholeTree returns the tree nodes without the ids, the ids depend on the test data loading order
+func wholeTree(s *treestorage.NestedSetsStorage) []treestorage.NestedSetsNode {
+	nodes, err := s.GetWholeTree(treestorage.ReadOptions{})
+	if err != nil {
+		return nil
+	}
+	for i := range nodes {
+		nodes[i].ID = 0
+	}
+	return nodes
+}
+
 func loadTestDataToDb() {
 	nodes := createTestNodes()
 
@@ -505,10 +2340,12 @@ func loadTestDataToDb() {
 	}
 	defer db.Close()
 
-	fullQuery := `INSERT INTO 
-					nodes (name, node_left, node_right) 
-				VALUES 
-					%s;`
+	fullQuery := `WITH tree AS (SELECT id FROM trees WHERE name = 'default')
+				INSERT INTO 
+					nodes (tree_id, name, node_left, node_right) 
+				SELECT tree.id, v.name, v.node_left, v.node_right
+				FROM tree, (VALUES 
+					%s) AS v (name, node_left, node_right);`
 	nodeFields := "('%s', %d, %d)"
 
 	nodesValues := make([]string, len(nodes))
@@ -522,6 +2359,16 @@ func loadTestDataToDb() {
 	if err != nil {
 		log.Fatal(err)
 	}
+
+	parentsQuery := `UPDATE nodes AS n
+					SET parent_id = (SELECT p.id FROM nodes AS p
+									WHERE p.tree_id = n.tree_id AND p.node_left < n.node_left AND p.node_right > n.node_right
+									ORDER BY p.node_left DESC
+									LIMIT 1);`
+	_, err = db.Exec(parentsQuery)
+	if err != nil {
+		log.Fatal(err)
+	}
 }
 
 func refillTestData() {
@@ -536,7 +2383,7 @@ func clearTestDataFromDb() {
 	}
 	defer db.Close()
 
-	query := "DELETE FROM nodes;"
+	query := "DELETE FROM audit; DELETE FROM nodes; DELETE FROM nodes_history; DELETE FROM trees WHERE name <> 'default';"
 	_, err = db.Exec(query)
 	if err != nil {
 		log.Fatal(err)
@@ -546,25 +2393,25 @@ func clearTestDataFromDb() {
 // added "Общешкольный родительский комитет" to "Совет лицея"
 func addNodeCase1() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 37},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 14},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 11},
-		{"Ученики", 9, 10},
-		{"Общешкольный родительский комитет", 12, 13},
-		{"Заместитель директора по информатизации", 15, 18},
-		{"Инженегр по ВТ", 16, 17},
-		{"Заместитель директора по ВР", 19, 26},
-		{"Служба сопровождения", 20, 21},
-		{"Методическое объединение педагогов дополнительного образования", 22, 23},
-		{"Методическое объединение классных руководителей", 24, 25},
-		{"Бухгалтерия", 27, 28},
-		{"Педагогический совет", 29, 30},
-		{"Заместитель директора по УВР", 31, 34},
-		{"Кафедры профильного образования", 32, 33},
-		{"Научно-методический совет", 35, 36},
+		{Name: "Директор", Left: 0, Right: 37},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 14},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Общешкольный родительский комитет", Left: 12, Right: 13},
+		{Name: "Заместитель директора по информатизации", Left: 15, Right: 18},
+		{Name: "Инженегр по ВТ", Left: 16, Right: 17},
+		{Name: "Заместитель директора по ВР", Left: 19, Right: 26},
+		{Name: "Служба сопровождения", Left: 20, Right: 21},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 22, Right: 23},
+		{Name: "Методическое объединение классных руководителей", Left: 24, Right: 25},
+		{Name: "Бухгалтерия", Left: 27, Right: 28},
+		{Name: "Педагогический совет", Left: 29, Right: 30},
+		{Name: "Заместитель директора по УВР", Left: 31, Right: 34},
+		{Name: "Кафедры профильного образования", Left: 32, Right: 33},
+		{Name: "Научно-методический совет", Left: 35, Right: 36},
 	}
 	return nodes
 }
@@ -572,26 +2419,26 @@ func addNodeCase1() []treestorage.NestedSetsNode {
 // added "Психолог" to "Заместитель директора по ВР"
 func addNodeCase2() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 39},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 14},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 11},
-		{"Ученики", 9, 10},
-		{"Общешкольный родительский комитет", 12, 13},
-		{"Заместитель директора по информатизации", 15, 18},
-		{"Инженегр по ВТ", 16, 17},
-		{"Заместитель директора по ВР", 19, 28},
-		{"Служба сопровождения", 20, 21},
-		{"Методическое объединение педагогов дополнительного образования", 22, 23},
-		{"Методическое объединение классных руководителей", 24, 25},
-		{"Психолог", 26, 27},
-		{"Бухгалтерия", 29, 30},
-		{"Педагогический совет", 31, 32},
-		{"Заместитель директора по УВР", 33, 36},
-		{"Кафедры профильного образования", 34, 35},
-		{"Научно-методический совет", 37, 38},
+		{Name: "Директор", Left: 0, Right: 39},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 14},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Общешкольный родительский комитет", Left: 12, Right: 13},
+		{Name: "Заместитель директора по информатизации", Left: 15, Right: 18},
+		{Name: "Инженегр по ВТ", Left: 16, Right: 17},
+		{Name: "Заместитель директора по ВР", Left: 19, Right: 28},
+		{Name: "Служба сопровождения", Left: 20, Right: 21},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 22, Right: 23},
+		{Name: "Методическое объединение классных руководителей", Left: 24, Right: 25},
+		{Name: "Психолог", Left: 26, Right: 27},
+		{Name: "Бухгалтерия", Left: 29, Right: 30},
+		{Name: "Педагогический совет", Left: 31, Right: 32},
+		{Name: "Заместитель директора по УВР", Left: 33, Right: 36},
+		{Name: "Кафедры профильного образования", Left: 34, Right: 35},
+		{Name: "Научно-методический совет", Left: 37, Right: 38},
 	}
 	return nodes
 }
@@ -599,51 +2446,126 @@ func addNodeCase2() []treestorage.NestedSetsNode {
 // added "Общее собрание трудового коллектива" to "Директор"
 func addNodeCase3() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 41},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 14},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 11},
-		{"Ученики", 9, 10},
-		{"Общешкольный родительский комитет", 12, 13},
-		{"Заместитель директора по информатизации", 15, 18},
-		{"Инженегр по ВТ", 16, 17},
-		{"Заместитель директора по ВР", 19, 28},
-		{"Служба сопровождения", 20, 21},
-		{"Методическое объединение педагогов дополнительного образования", 22, 23},
-		{"Методическое объединение классных руководителей", 24, 25},
-		{"Психолог", 26, 27},
-		{"Бухгалтерия", 29, 30},
-		{"Педагогический совет", 31, 32},
-		{"Заместитель директора по УВР", 33, 36},
-		{"Кафедры профильного образования", 34, 35},
-		{"Научно-методический совет", 37, 38},
-		{"Общее собрание трудового коллектива", 39, 40},
+		{Name: "Директор", Left: 0, Right: 41},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 14},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Общешкольный родительский комитет", Left: 12, Right: 13},
+		{Name: "Заместитель директора по информатизации", Left: 15, Right: 18},
+		{Name: "Инженегр по ВТ", Left: 16, Right: 17},
+		{Name: "Заместитель директора по ВР", Left: 19, Right: 28},
+		{Name: "Служба сопровождения", Left: 20, Right: 21},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 22, Right: 23},
+		{Name: "Методическое объединение классных руководителей", Left: 24, Right: 25},
+		{Name: "Психолог", Left: 26, Right: 27},
+		{Name: "Бухгалтерия", Left: 29, Right: 30},
+		{Name: "Педагогический совет", Left: 31, Right: 32},
+		{Name: "Заместитель директора по УВР", Left: 33, Right: 36},
+		{Name: "Кафедры профильного образования", Left: 34, Right: 35},
+		{Name: "Научно-методический совет", Left: 37, Right: 38},
+		{Name: "Общее собрание трудового коллектива", Left: 39, Right: 40},
 	}
 	return nodes
 }
 
 // Removing a node without children // removed "Служба сопровождения"
+func addNodePositionCase1() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 37},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 26},
+		{Name: "Психолог", Left: 18, Right: 19},
+		{Name: "Служба сопровождения", Left: 20, Right: 21},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 22, Right: 23},
+		{Name: "Методическое объединение классных руководителей", Left: 24, Right: 25},
+		{Name: "Бухгалтерия", Left: 27, Right: 28},
+		{Name: "Педагогический совет", Left: 29, Right: 30},
+		{Name: "Заместитель директора по УВР", Left: 31, Right: 34},
+		{Name: "Кафедры профильного образования", Left: 32, Right: 33},
+		{Name: "Научно-методический совет", Left: 35, Right: 36},
+	}
+	return nodes
+}
+
+func addNodePositionCase2() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 37},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 26},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Психолог", Left: 20, Right: 21},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 22, Right: 23},
+		{Name: "Методическое объединение классных руководителей", Left: 24, Right: 25},
+		{Name: "Бухгалтерия", Left: 27, Right: 28},
+		{Name: "Педагогический совет", Left: 29, Right: 30},
+		{Name: "Заместитель директора по УВР", Left: 31, Right: 34},
+		{Name: "Кафедры профильного образования", Left: 32, Right: 33},
+		{Name: "Научно-методический совет", Left: 35, Right: 36},
+	}
+	return nodes
+}
+
+func addNodePositionCase3() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 37},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 26},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Психолог", Left: 24, Right: 25},
+		{Name: "Бухгалтерия", Left: 27, Right: 28},
+		{Name: "Педагогический совет", Left: 29, Right: 30},
+		{Name: "Заместитель директора по УВР", Left: 31, Right: 34},
+		{Name: "Кафедры профильного образования", Left: 32, Right: 33},
+		{Name: "Научно-методический совет", Left: 35, Right: 36},
+	}
+	return nodes
+}
+
 func removeNodeCase1() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 33},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 12},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 11},
-		{"Ученики", 9, 10},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по ВР", 17, 22},
-		{"Методическое объединение педагогов дополнительного образования", 18, 19},
-		{"Методическое объединение классных руководителей", 20, 21},
-		{"Бухгалтерия", 23, 24},
-		{"Педагогический совет", 25, 26},
-		{"Заместитель директора по УВР", 27, 30},
-		{"Кафедры профильного образования", 28, 29},
-		{"Научно-методический совет", 31, 32},
+		{Name: "Директор", Left: 0, Right: 33},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 22},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 18, Right: 19},
+		{Name: "Методическое объединение классных руководителей", Left: 20, Right: 21},
+		{Name: "Бухгалтерия", Left: 23, Right: 24},
+		{Name: "Педагогический совет", Left: 25, Right: 26},
+		{Name: "Заместитель директора по УВР", Left: 27, Right: 30},
+		{Name: "Кафедры профильного образования", Left: 28, Right: 29},
+		{Name: "Научно-методический совет", Left: 31, Right: 32},
 	}
 	return nodes
 }
@@ -651,22 +2573,22 @@ func removeNodeCase1() []treestorage.NestedSetsNode {
 // Removing a node with children // removed "Совет лицея"
 func removeNodeCase2() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 31},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Благотворительный фонд \"Развитие школы\"", 5, 6},
-		{"Ученическое самоуправление", 7, 10},
-		{"Ученики", 8, 9},
-		{"Заместитель директора по информатизации", 11, 14},
-		{"Инженегр по ВТ", 12, 13},
-		{"Заместитель директора по ВР", 15, 20},
-		{"Методическое объединение педагогов дополнительного образования", 16, 17},
-		{"Методическое объединение классных руководителей", 18, 19},
-		{"Бухгалтерия", 21, 22},
-		{"Педагогический совет", 23, 24},
-		{"Заместитель директора по УВР", 25, 28},
-		{"Кафедры профильного образования", 26, 27},
-		{"Научно-методический совет", 29, 30},
+		{Name: "Директор", Left: 0, Right: 31},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 5, Right: 6},
+		{Name: "Ученическое самоуправление", Left: 7, Right: 10},
+		{Name: "Ученики", Left: 8, Right: 9},
+		{Name: "Заместитель директора по информатизации", Left: 11, Right: 14},
+		{Name: "Инженегр по ВТ", Left: 12, Right: 13},
+		{Name: "Заместитель директора по ВР", Left: 15, Right: 20},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 16, Right: 17},
+		{Name: "Методическое объединение классных руководителей", Left: 18, Right: 19},
+		{Name: "Бухгалтерия", Left: 21, Right: 22},
+		{Name: "Педагогический совет", Left: 23, Right: 24},
+		{Name: "Заместитель директора по УВР", Left: 25, Right: 28},
+		{Name: "Кафедры профильного образования", Left: 26, Right: 27},
+		{Name: "Научно-методический совет", Left: 29, Right: 30},
 	}
 	return nodes
 }
@@ -674,46 +2596,89 @@ func removeNodeCase2() []treestorage.NestedSetsNode {
 // Removing a tree root // removed "Директор"
 func removeNodeCase3() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Заместитель директора по АХЧ", 0, 3},
-		{"Обслуживающий персонал", 1, 2},
-		{"Благотворительный фонд \"Развитие школы\"", 4, 5},
-		{"Ученическое самоуправление", 6, 9},
-		{"Ученики", 7, 8},
-		{"Заместитель директора по информатизации", 10, 13},
-		{"Инженегр по ВТ", 11, 12},
-		{"Заместитель директора по ВР", 14, 19},
-		{"Методическое объединение педагогов дополнительного образования", 15, 16},
-		{"Методическое объединение классных руководителей", 17, 18},
-		{"Бухгалтерия", 20, 21},
-		{"Педагогический совет", 22, 23},
-		{"Заместитель директора по УВР", 24, 27},
-		{"Кафедры профильного образования", 25, 26},
-		{"Научно-методический совет", 28, 29},
+		{Name: "Заместитель директора по АХЧ", Left: 0, Right: 3},
+		{Name: "Обслуживающий персонал", Left: 1, Right: 2},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 4, Right: 5},
+		{Name: "Ученическое самоуправление", Left: 6, Right: 9},
+		{Name: "Ученики", Left: 7, Right: 8},
+		{Name: "Заместитель директора по информатизации", Left: 10, Right: 13},
+		{Name: "Инженегр по ВТ", Left: 11, Right: 12},
+		{Name: "Заместитель директора по ВР", Left: 14, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 15, Right: 16},
+		{Name: "Методическое объединение классных руководителей", Left: 17, Right: 18},
+		{Name: "Бухгалтерия", Left: 20, Right: 21},
+		{Name: "Педагогический совет", Left: 22, Right: 23},
+		{Name: "Заместитель директора по УВР", Left: 24, Right: 27},
+		{Name: "Кафедры профильного образования", Left: 25, Right: 26},
+		{Name: "Научно-методический совет", Left: 28, Right: 29},
 	}
 	return nodes
 }
 
 // left direction move to the right parent node
+func removeSubtreeCase() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 27},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Заместитель директора по информатизации", Left: 5, Right: 8},
+		{Name: "Инженегр по ВТ", Left: 6, Right: 7},
+		{Name: "Заместитель директора по ВР", Left: 9, Right: 16},
+		{Name: "Служба сопровождения", Left: 10, Right: 11},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 12, Right: 13},
+		{Name: "Методическое объединение классных руководителей", Left: 14, Right: 15},
+		{Name: "Бухгалтерия", Left: 17, Right: 18},
+		{Name: "Педагогический совет", Left: 19, Right: 20},
+		{Name: "Заместитель директора по УВР", Left: 21, Right: 24},
+		{Name: "Кафедры профильного образования", Left: 22, Right: 23},
+		{Name: "Научно-методический совет", Left: 25, Right: 26},
+	}
+	return nodes
+}
+
+func reassignChildrenCase() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 33},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 10},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 4, Right: 5},
+		{Name: "Ученическое самоуправление", Left: 6, Right: 9},
+		{Name: "Ученики", Left: 7, Right: 8},
+		{Name: "Заместитель директора по информатизации", Left: 11, Right: 14},
+		{Name: "Инженегр по ВТ", Left: 12, Right: 13},
+		{Name: "Заместитель директора по ВР", Left: 15, Right: 22},
+		{Name: "Служба сопровождения", Left: 16, Right: 17},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 18, Right: 19},
+		{Name: "Методическое объединение классных руководителей", Left: 20, Right: 21},
+		{Name: "Бухгалтерия", Left: 23, Right: 24},
+		{Name: "Педагогический совет", Left: 25, Right: 26},
+		{Name: "Заместитель директора по УВР", Left: 27, Right: 30},
+		{Name: "Кафедры профильного образования", Left: 28, Right: 29},
+		{Name: "Научно-методический совет", Left: 31, Right: 32},
+	}
+	return nodes
+}
+
 func moveNodeCase1() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 12},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 11},
-		{"Ученики", 9, 10},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по ВР", 17, 26},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 20, 21},
-		{"Методическое объединение классных руководителей", 22, 23},
-		{"Бухгалтерия", 27, 28},
-		{"Педагогический совет", 24, 25},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 26},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 27, Right: 28},
+		{Name: "Педагогический совет", Left: 24, Right: 25},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
 	}
 	return nodes
 }
@@ -721,24 +2686,24 @@ func moveNodeCase1() []treestorage.NestedSetsNode {
 // right direction move to the left parent node
 func moveNodeCase2() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 16, 17},
-		{"Благотворительный фонд \"Развитие школы\"", 5, 6},
-		{"Ученическое самоуправление", 7, 10},
-		{"Ученики", 8, 9},
-		{"Заместитель директора по информатизации", 11, 14},
-		{"Инженегр по ВТ", 12, 13},
-		{"Заместитель директора по ВР", 15, 24},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 20, 21},
-		{"Методическое объединение классных руководителей", 22, 23},
-		{"Бухгалтерия", 25, 26},
-		{"Педагогический совет", 27, 28},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 16, Right: 17},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 5, Right: 6},
+		{Name: "Ученическое самоуправление", Left: 7, Right: 10},
+		{Name: "Ученики", Left: 8, Right: 9},
+		{Name: "Заместитель директора по информатизации", Left: 11, Right: 14},
+		{Name: "Инженегр по ВТ", Left: 12, Right: 13},
+		{Name: "Заместитель директора по ВР", Left: 15, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
 	}
 	return nodes
 }
@@ -746,24 +2711,24 @@ func moveNodeCase2() []treestorage.NestedSetsNode {
 // move node in the same branch
 func moveNodeCase3() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 12},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 11},
-		{"Ученики", 9, 10},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по ВР", 17, 24},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 21, 22},
-		{"Методическое объединение классных руководителей", 20, 23},
-		{"Бухгалтерия", 25, 26},
-		{"Педагогический совет", 27, 28},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 21, Right: 22},
+		{Name: "Методическое объединение классных руководителей", Left: 20, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
 	}
 	return nodes
 }
@@ -771,24 +2736,24 @@ func moveNodeCase3() []treestorage.NestedSetsNode {
 // left moving to the right parent node
 func moveNodeCase4() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 12},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 11},
-		{"Ученики", 9, 10},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по ВР", 17, 26},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 20, 21},
-		{"Методическое объединение классных руководителей", 22, 23},
-		{"Бухгалтерия", 27, 28},
-		{"Педагогический совет", 24, 25},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 26},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 27, Right: 28},
+		{Name: "Педагогический совет", Left: 24, Right: 25},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
 	}
 	return nodes
 }
@@ -796,24 +2761,24 @@ func moveNodeCase4() []treestorage.NestedSetsNode {
 // moving down alang branch
 func moveNodeCase5() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 12},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 9, 10},
-		{"Ученики", 8, 11},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по ВР", 17, 24},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 20, 21},
-		{"Методическое объединение классных руководителей", 22, 23},
-		{"Бухгалтерия", 25, 26},
-		{"Педагогический совет", 27, 28},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 9, Right: 10},
+		{Name: "Ученики", Left: 8, Right: 11},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
 	}
 	return nodes
 }
@@ -821,24 +2786,24 @@ func moveNodeCase5() []treestorage.NestedSetsNode {
 // moving up along branch to the right parent node
 func moveNodeCase6() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 12},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 9},
-		{"Ученики", 10, 11},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по ВР", 17, 24},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 20, 21},
-		{"Методическое объединение классных руководителей", 22, 23},
-		{"Бухгалтерия", 25, 26},
-		{"Педагогический совет", 27, 28},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 9},
+		{Name: "Ученики", Left: 10, Right: 11},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
 	}
 	return nodes
 }
@@ -846,24 +2811,24 @@ func moveNodeCase6() []treestorage.NestedSetsNode {
 // moving up along branch to the left parent node
 func moveNodeCase7() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 3, 6},
-		{"Обслуживающий персонал", 4, 5},
-		{"Совет лицея", 1, 2},
-		{"Благотворительный фонд \"Развитие школы\"", 7, 8},
-		{"Ученическое самоуправление", 9, 12},
-		{"Ученики", 10, 11},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по ВР", 17, 24},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 20, 21},
-		{"Методическое объединение классных руководителей", 22, 23},
-		{"Бухгалтерия", 25, 26},
-		{"Педагогический совет", 27, 28},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 3, Right: 6},
+		{Name: "Обслуживающий персонал", Left: 4, Right: 5},
+		{Name: "Совет лицея", Left: 1, Right: 2},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 7, Right: 8},
+		{Name: "Ученическое самоуправление", Left: 9, Right: 12},
+		{Name: "Ученики", Left: 10, Right: 11},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
 	}
 	return nodes
 }
@@ -871,48 +2836,288 @@ func moveNodeCase7() []treestorage.NestedSetsNode {
 // moving down alnog pranch
 func moveNodeCase8() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 9, 10},
-		{"Благотворительный фонд \"Развитие школы\"", 5, 6},
-		{"Ученическое самоуправление", 7, 12},
-		{"Ученики", 8, 11},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по ВР", 17, 24},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 20, 21},
-		{"Методическое объединение классных руководителей", 22, 23},
-		{"Бухгалтерия", 25, 26},
-		{"Педагогический совет", 27, 28},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 9, Right: 10},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 5, Right: 6},
+		{Name: "Ученическое самоуправление", Left: 7, Right: 12},
+		{Name: "Ученики", Left: 8, Right: 11},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+	}
+	return nodes
+}
+
+func moveSubtreeCase1() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Заместитель директора по информатизации", Left: 5, Right: 8},
+		{Name: "Инженегр по ВТ", Left: 6, Right: 7},
+		{Name: "Заместитель директора по ВР", Left: 9, Right: 24},
+		{Name: "Служба сопровождения", Left: 10, Right: 11},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 12, Right: 13},
+		{Name: "Методическое объединение классных руководителей", Left: 14, Right: 15},
+		{Name: "Совет лицея", Left: 16, Right: 23},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 17, Right: 18},
+		{Name: "Ученическое самоуправление", Left: 19, Right: 22},
+		{Name: "Ученики", Left: 20, Right: 21},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+	}
+	return nodes
+}
+
+func moveSubtreeCase2() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 8},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Заместитель директора по УВР", Left: 4, Right: 7},
+		{Name: "Кафедры профильного образования", Left: 5, Right: 6},
+		{Name: "Совет лицея", Left: 9, Right: 16},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 10, Right: 11},
+		{Name: "Ученическое самоуправление", Left: 12, Right: 15},
+		{Name: "Ученики", Left: 13, Right: 14},
+		{Name: "Заместитель директора по информатизации", Left: 17, Right: 20},
+		{Name: "Инженегр по ВТ", Left: 18, Right: 19},
+		{Name: "Заместитель директора по ВР", Left: 21, Right: 28},
+		{Name: "Служба сопровождения", Left: 22, Right: 23},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 24, Right: 25},
+		{Name: "Методическое объединение классных руководителей", Left: 26, Right: 27},
+		{Name: "Бухгалтерия", Left: 29, Right: 30},
+		{Name: "Педагогический совет", Left: 31, Right: 32},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+	}
+	return nodes
+}
+
+func moveNodePositionCase1() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 26},
+		{Name: "Педагогический совет", Left: 18, Right: 19},
+		{Name: "Служба сопровождения", Left: 20, Right: 21},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 22, Right: 23},
+		{Name: "Методическое объединение классных руководителей", Left: 24, Right: 25},
+		{Name: "Бухгалтерия", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+	}
+	return nodes
+}
+
+func moveNodePositionCase2() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 5, Right: 6},
+		{Name: "Ученическое самоуправление", Left: 7, Right: 10},
+		{Name: "Ученики", Left: 8, Right: 9},
+		{Name: "Заместитель директора по информатизации", Left: 11, Right: 14},
+		{Name: "Инженегр по ВТ", Left: 12, Right: 13},
+		{Name: "Заместитель директора по ВР", Left: 15, Right: 22},
+		{Name: "Служба сопровождения", Left: 16, Right: 17},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 18, Right: 19},
+		{Name: "Методическое объединение классных руководителей", Left: 20, Right: 21},
+		{Name: "Бухгалтерия", Left: 23, Right: 24},
+		{Name: "Совет лицея", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+	}
+	return nodes
+}
+
+func moveNodePositionCase3() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Ученическое самоуправление", Left: 6, Right: 7},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 8, Right: 9},
+		{Name: "Ученики", Left: 10, Right: 11},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+	}
+	return nodes
+}
+
+func moveNodePositionCase4() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 5, Right: 6},
+		{Name: "Ученическое самоуправление", Left: 7, Right: 12},
+		{Name: "Ученики", Left: 8, Right: 11},
+		{Name: "Совет лицея", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+	}
+	return nodes
+}
+
+func moveSubtreeCase3() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Заместитель директора по информатизации", Left: 5, Right: 8},
+		{Name: "Инженегр по ВТ", Left: 6, Right: 7},
+		{Name: "Заместитель директора по ВР", Left: 9, Right: 24},
+		{Name: "Совет лицея", Left: 10, Right: 17},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 11, Right: 12},
+		{Name: "Ученическое самоуправление", Left: 13, Right: 16},
+		{Name: "Ученики", Left: 14, Right: 15},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+	}
+	return nodes
+}
+
+func reorderChildrenCase1() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 20, Right: 21},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 22, Right: 23},
+		{Name: "Методическое объединение классных руководителей", Left: 18, Right: 19},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+	}
+	return nodes
+}
+
+func reorderChildrenCase2() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 10, Right: 11},
+		{Name: "Ученическое самоуправление", Left: 6, Right: 9},
+		{Name: "Ученики", Left: 7, Right: 8},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+	}
+	return nodes
+}
+
+func sortChildrenCase() []treestorage.NestedSetsNode {
+	nodes := []treestorage.NestedSetsNode{
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 22, Right: 23},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 18, Right: 19},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
 	}
 	return nodes
 }
 
 func renameNodeCase() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 12},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 11},
-		{"Ученики", 9, 10},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по воспитательной работе", 17, 24},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 20, 21},
-		{"Методическое объединение классных руководителей", 22, 23},
-		{"Бухгалтерия", 25, 26},
-		{"Педагогический совет", 27, 28},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по воспитательной работе", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
 	}
 	return nodes
 }
@@ -943,49 +3148,49 @@ func getChildrenCase1() []string {
 
 func addingRootCase() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 12},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 11},
-		{"Ученики", 9, 10},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по ВР", 17, 24},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 20, 21},
-		{"Методическое объединение классных руководителей", 22, 23},
-		{"Бухгалтерия", 25, 26},
-		{"Педагогический совет", 27, 28},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
-		{"Директор колледжа", 36, 37},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
+		{Name: "Директор колледжа", Left: 36, Right: 37},
 	}
 	return nodes
 }
 
 func createTestNodes() []treestorage.NestedSetsNode {
 	nodes := []treestorage.NestedSetsNode{
-		{"Директор", 0, 35},
-		{"Заместитель директора по АХЧ", 1, 4},
-		{"Обслуживающий персонал", 2, 3},
-		{"Совет лицея", 5, 12},
-		{"Благотворительный фонд \"Развитие школы\"", 6, 7},
-		{"Ученическое самоуправление", 8, 11},
-		{"Ученики", 9, 10},
-		{"Заместитель директора по информатизации", 13, 16},
-		{"Инженегр по ВТ", 14, 15},
-		{"Заместитель директора по ВР", 17, 24},
-		{"Служба сопровождения", 18, 19},
-		{"Методическое объединение педагогов дополнительного образования", 20, 21},
-		{"Методическое объединение классных руководителей", 22, 23},
-		{"Бухгалтерия", 25, 26},
-		{"Педагогический совет", 27, 28},
-		{"Заместитель директора по УВР", 29, 32},
-		{"Кафедры профильного образования", 30, 31},
-		{"Научно-методический совет", 33, 34},
+		{Name: "Директор", Left: 0, Right: 35},
+		{Name: "Заместитель директора по АХЧ", Left: 1, Right: 4},
+		{Name: "Обслуживающий персонал", Left: 2, Right: 3},
+		{Name: "Совет лицея", Left: 5, Right: 12},
+		{Name: "Благотворительный фонд \"Развитие школы\"", Left: 6, Right: 7},
+		{Name: "Ученическое самоуправление", Left: 8, Right: 11},
+		{Name: "Ученики", Left: 9, Right: 10},
+		{Name: "Заместитель директора по информатизации", Left: 13, Right: 16},
+		{Name: "Инженегр по ВТ", Left: 14, Right: 15},
+		{Name: "Заместитель директора по ВР", Left: 17, Right: 24},
+		{Name: "Служба сопровождения", Left: 18, Right: 19},
+		{Name: "Методическое объединение педагогов дополнительного образования", Left: 20, Right: 21},
+		{Name: "Методическое объединение классных руководителей", Left: 22, Right: 23},
+		{Name: "Бухгалтерия", Left: 25, Right: 26},
+		{Name: "Педагогический совет", Left: 27, Right: 28},
+		{Name: "Заместитель директора по УВР", Left: 29, Right: 32},
+		{Name: "Кафедры профильного образования", Left: 30, Right: 31},
+		{Name: "Научно-методический совет", Left: 33, Right: 34},
 	}
 	return nodes
 }
@@ -0,0 +1,189 @@
+package treestorage
+
+import (
+	"context"
+	"fmt"
+	"sort"
+)
+
+// IssueKind is the kind of a nested sets integrity issue
+type IssueKind string
+
+// The integrity issue kinds
+const (
+	IssueInvalidInterval   IssueKind = "invalid_interval"   // left >= right
+	IssueDuplicateBoundary IssueKind = "duplicate_boundary" // a number is used by several boundaries
+	IssueMissingBoundary   IssueKind = "missing_boundary"   // a number of 0..2n-1 is not used
+	IssueOverlap           IssueKind = "overlap"            // the intervals intersect without nesting
+	IssueGap               IssueKind = "gap"                // the children do not fill their parent interval
+	IssueRootOrder         IssueKind = "root_order"         // the roots do not follow each other from 0
+)
+
+// VerifyIssue is a nested sets integrity issue
+type VerifyIssue struct {
+	Kind     IssueKind
+	Nodes    []string // the names of the nodes involved
+	Boundary int      // the boundary number the issue is found at
+	Message  string
+}
+
+// VerifyReport is the result of the tree integrity verification
+type VerifyReport struct {
+	Tree      string
+	NodeCount int
+	Valid     bool
+	Issues    []VerifyIssue
+}
+
+// VerifyNodes checks the nested sets nodes of a single tree are consistent
+func VerifyNodes(nodes []NestedSetsNode) VerifyReport {
+	v := verifier{report: VerifyReport{NodeCount: len(nodes), Issues: []VerifyIssue{}}}
+
+	v.checkBoundaries(nodes)
+	v.checkStructure(nodes)
+
+	v.report.Valid = len(v.report.Issues) == 0
+	return v.report
+}
+
+// Verify checks the integrity of the tree
+func (s *NestedSetsStorage) Verify() (VerifyReport, error) {
+	return s.VerifyContext(context.Background())
+}
+
+// VerifyContext checks the integrity of the tree
+func (s *NestedSetsStorage) VerifyContext(ctx context.Context) (VerifyReport, error) {
+	nodes, err := s.GetWholeTreeContext(ctx, ReadOptions{})
+	if err != nil {
+		return VerifyReport{}, err
+	}
+
+	report := VerifyNodes(nodes)
+	report.Tree = s.tree
+	return report, nil
+}
+
+// Rebuild recomputes the left and right of all the tree nodes from their parent ids,
+// the siblings keep their order
+func (s *NestedSetsStorage) Rebuild() error {
+	return s.RebuildContext(context.Background())
+}
+
+// RebuildContext recomputes the left and right of all the tree nodes from their parent ids,
+// the siblings keep their order
+func (s *NestedSetsStorage) RebuildContext(ctx context.Context) error {
+	_, err := s.runOp(ctx, nodeOp{auditEntry: auditEntry{op: OpRebuild}, run: callOp(`SELECT rebuild_tree($1);`)})
+	return err
+}
+
+type verifier struct {
+	report VerifyReport
+}
+
+func (v *verifier) add(kind IssueKind, boundary int, message string, nodes ...string) {
+	v.report.Issues = append(v.report.Issues, VerifyIssue{
+		Kind:     kind,
+		Nodes:    nodes,
+		Boundary: boundary,
+		Message:  message})
+}
+
+// checkBoundaries checks every number of 0..2n-1 is used by exactly one boundary
+func (v *verifier) checkBoundaries(nodes []NestedSetsNode) {
+	owners := make(map[int][]string, 2*len(nodes))
+	for _, node := range nodes {
+		if node.Left >= node.Right {
+			v.add(IssueInvalidInterval, node.Left,
+				fmt.Sprintf("left %d is not less than right %d", node.Left, node.Right), node.Name)
+		}
+		owners[node.Left] = append(owners[node.Left], node.Name)
+		owners[node.Right] = append(owners[node.Right], node.Name)
+	}
+
+	boundaries := make([]int, 0, len(owners))
+	for boundary := range owners {
+		boundaries = append(boundaries, boundary)
+	}
+	sort.Ints(boundaries)
+	for _, boundary := range boundaries {
+		if len(owners[boundary]) > 1 {
+			v.add(IssueDuplicateBoundary, boundary,
+				fmt.Sprintf("%d is used %d times", boundary, len(owners[boundary])), owners[boundary]...)
+		}
+	}
+
+	for boundary := 0; boundary < 2*len(nodes); boundary++ {
+		if _, ok := owners[boundary]; !ok {
+			v.add(IssueMissingBoundary, boundary, fmt.Sprintf("%d is not used", boundary))
+		}
+	}
+}
+
+// checkStructure checks the intervals are nested and the children fill their parents
+// and the roots follow each other from 0
+func (v *verifier) checkStructure(nodes []NestedSetsNode) {
+	sorted := make([]NestedSetsNode, 0, len(nodes))
+	for _, node := range nodes {
+		if node.Left < node.Right {
+			sorted = append(sorted, node)
+		}
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Left != sorted[j].Left {
+			return sorted[i].Left < sorted[j].Left
+		}
+		return sorted[i].Right > sorted[j].Right
+	})
+
+	// the open parents, next is the left expected of the next child
+	type frame struct {
+		node NestedSetsNode
+		next int
+	}
+	var stack []frame
+	nextRoot := 0
+
+	closeFrame := func() {
+		top := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if top.next != top.node.Right {
+			v.add(IssueGap, top.next,
+				fmt.Sprintf("the children end at %d before right %d", top.next-1, top.node.Right), top.node.Name)
+		}
+	}
+
+	for _, node := range sorted {
+		for len(stack) > 0 && stack[len(stack)-1].node.Right < node.Left {
+			closeFrame()
+		}
+
+		if len(stack) == 0 {
+			if node.Left != nextRoot {
+				v.add(IssueRootOrder, node.Left,
+					fmt.Sprintf("the root starts at %d instead of %d", node.Left, nextRoot), node.Name)
+			}
+			nextRoot = node.Right + 1
+			stack = append(stack, frame{node: node, next: node.Left + 1})
+			continue
+		}
+
+		parent := &stack[len(stack)-1]
+		if node.Right > parent.node.Right {
+			v.add(IssueOverlap, node.Left,
+				fmt.Sprintf("[%d, %d] intersects [%d, %d]", node.Left, node.Right, parent.node.Left, parent.node.Right),
+				parent.node.Name, node.Name)
+			continue
+		}
+		if node.Left != parent.next {
+			v.add(IssueGap, parent.next,
+				fmt.Sprintf("the child starts at %d instead of %d", node.Left, parent.next),
+				parent.node.Name, node.Name)
+		}
+		parent.next = node.Right + 1
+		stack = append(stack, frame{node: node, next: node.Left + 1})
+	}
+
+	for len(stack) > 0 {
+		closeFrame()
+	}
+}
@@ -0,0 +1,45 @@
+package treestorage
+
+import (
+	"context"
+	"database/sql"
+)
+
+type versionKey struct{}
+
+// VersionCheck is the tree version expected by the writes run with its context
+// and the version they produce
+type VersionCheck struct {
+	// Expected is the version the tree must be at for a write to run, negative means any version
+	Expected int64
+
+	// Committed is the tree version after the last successful write
+	Committed int64
+}
+
+// WithVersionCheck returns the context making the storage writes fail with ErrVersionMismatch
+// unless the tree is at the version check.Expected and report the version they produce to check
+func WithVersionCheck(ctx context.Context, check *VersionCheck) context.Context {
+	return context.WithValue(ctx, versionKey{}, check)
+}
+
+// TreeVersion returns the tree version, it grows with every change of the tree nodes
+func (s *NestedSetsStorage) TreeVersion() (int64, error) {
+	return s.TreeVersionContext(context.Background())
+}
+
+// TreeVersionContext returns the tree version, it grows with every change of the tree nodes
+func (s *NestedSetsStorage) TreeVersionContext(ctx context.Context) (int64, error) {
+	ctx, cancel := s.withTimeout(ctx, OpGetVersion)
+	defer cancel()
+
+	var version int64
+	err := s.db.QueryRowContext(ctx, `SELECT version FROM trees WHERE name = $1;`, s.tree).Scan(&version)
+	if err == sql.ErrNoRows {
+		return 0, ErrTreeNotFound
+	}
+	if err != nil {
+		return 0, storageError(err)
+	}
+	return version, nil
+}